@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	u "video-converter/utils"
+)
+
+func runConfig(args []string) int {
+	fs := newFlagSet("config")
+	var jf jobFlags
+	jf.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := jf.config.load(&jf.sel, &jf.profile, &jf.output)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	profiles, err := jf.profile.load(cfg)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	if path := cfg.Path(); path != "" {
+		fmt.Printf("Config: %s\n", path)
+	} else {
+		fmt.Printf("Config: %s not found, using defaults\n", jf.config.path)
+	}
+	fmt.Println("Global:")
+	printSettings(os.Stdout, cfg.Global(), profiles)
+
+	// без путей - только общие настройки: обходить текущий каталог ради них незачем
+	if fs.NArg() == 0 {
+		return exitOK
+	}
+	files, err := jf.discover.discover(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	code := exitOK
+	for _, file := range files {
+		settings, err := cfg.Resolve(file)
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+			code = exitFailed
+			continue
+		}
+		fmt.Printf("%s:\n", file)
+		printSettings(os.Stdout, settings, profiles)
+	}
+	return code
+}
+
+// Итоговые настройки и откуда взято каждое значение:
+//
+//	profile          "avc720-anime"  # /media/anime/.video-converter.yaml
+func printSettings(w io.Writer, settings u.Resolved, profiles u.Profiles) {
+	for _, f := range settings.Fields() {
+		value := f.Value
+		if f.Name == "profile" && *settings.Profile == "" {
+			// профиль не выбран - берется профиль по умолчанию из файла профилей
+			value = strconv.Quote(profiles.Default)
+		}
+		fmt.Fprintf(w, "  %-16s %-24s # %s\n", f.Name, value, settings.Sources[f.Name])
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	u "video-converter/utils"
+)
+
+// Настройки конвертации одного файла
+type converter struct {
+	ffmpegPath string
+	config     *u.Config  // настройки по каталогам и сериалам
+	profiles   u.Profiles // профили, из которых настройки выбирают профиль файла
+	profile    u.Profile  // профиль по умолчанию для этого запуска
+	noRemux    bool
+	force      bool       // перекодировать, даже если готовый файл уже есть
+	fullDecode bool       // проверять результат полным декодированием
+	display    *u.Display // вывод сообщений и прогресса
+	history    *u.History // история конвертаций, nil - без истории
+
+	// потоков на одно кодирование в профиле файла по расписанию, nil - из профиля
+	threads func(profile u.Profile) int
+}
+
+// Готовый файл от прошлого запуска прошел проверку, исходник пропускаем
+var errAlreadyConverted = errors.New("already converted")
+
+// Что делать с файлом после ошибки
+type errorAction int
+
+const (
+	actionSkip  errorAction = iota // пропустить файл и продолжить
+	actionRetry                    // попробовать еще раз
+	actionAbort                    // остановить весь пакет
+)
+
+func (a errorAction) String() string {
+	switch a {
+	case actionRetry:
+		return "retry"
+	case actionAbort:
+		return "abort"
+	default:
+		return "skip"
+	}
+}
+
+// Решаем по типу ошибки, что делать дальше
+func actionFor(err error) errorAction {
+	var encodeErr *u.EncodeError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// прервано по сигналу: повторять нельзя
+		return actionAbort
+	case errors.Is(err, u.ErrToolNotFound):
+		// без ffmpeg/ffprobe остальные файлы тоже не сконвертировать
+		return actionAbort
+	case errors.As(err, &encodeErr):
+		return actionRetry
+	default:
+		// ошибки ffprobe, нет нужного аудио, имя не подошло под паттерны
+		return actionSkip
+	}
+}
+
+// Что будет сделано с одним файлом: задание и данные, по которым оно составлено
+type filePlan struct {
+	job     u.Job
+	streams u.AllStreamInfo
+	scale   u.Scale // размер кадра при кодировании, при перепаковке не заполняется
+}
+
+// Составляем задание для файла: настройки, ffprobe, выбор дорожек, решение, размер кадра, имя
+func (c *converter) prepare(ctx context.Context, inputFile string) (filePlan, error) {
+	// Настройки файла: общие, переопределения для сериала и каталога, флаги
+	settings, err := c.config.Resolve(inputFile)
+	if err != nil {
+		return filePlan{}, err
+	}
+	profile := c.profile
+	if *settings.Profile != "" && *settings.Profile != profile.Name {
+		if profile, err = c.profiles.Get(*settings.Profile); err != nil {
+			return filePlan{}, err
+		}
+	}
+	// у профиля из переопределений может быть другой кодек: потоки считаем для него
+	if c.threads != nil {
+		profile.Threads = c.threads(profile)
+	}
+	output, err := settings.OutputOptions()
+	if err != nil {
+		return filePlan{}, err
+	}
+
+	// Получаем информацию о потоках аудио и субтитров с помощью ffprobe
+	streams, err := u.GetStreamsInfo(ctx, inputFile)
+	if err != nil {
+		return filePlan{}, err
+	}
+
+	// Выбираем дорожки по списку предпочтительных языков
+	sel := u.SelectStreams(streams, settings.SelectOptions())
+
+	// Решаем, перекодировать видео или достаточно перепаковать файл
+	decision := u.Decide(streams.Video, profile)
+	if c.noRemux && decision.Remux {
+		decision = u.Decision{Reason: "remux disabled by -no-remux"}
+	}
+
+	// Решаем, нужно ли уменьшать кадр: никогда не увеличиваем
+	var scale u.Scale
+	height := streams.Video.Height
+	if !decision.Remux {
+		scale = u.ScaleFor(streams.Video, profile.Height)
+		height = scale.Height
+	}
+
+	// получаем новое имя для перeкодированного файла по реальной высоте кадра
+	outputFile, err := u.OutputPath(inputFile, output, profile, height)
+	if err != nil {
+		return filePlan{}, err
+	}
+	if sameFile(inputFile, outputFile) {
+		return filePlan{}, fmt.Errorf("output %s would overwrite the source", outputFile)
+	}
+
+	job := u.Job{
+		Input:      inputFile,
+		Output:     outputFile,
+		Selection:  sel,
+		Profile:    profile,
+		Decision:   decision,
+		FullDecode: c.fullDecode,
+	}
+	return filePlan{job: job, streams: streams, scale: scale}, nil
+}
+
+// Полная обработка одного файла: ffprobe, выбор дорожек, решение, имя, ffmpeg
+func (c *converter) processFile(ctx context.Context, inputFile string, res *fileResult) error {
+	fmt.Fprintf(c.display, "Processing file: %s\n", inputFile)
+
+	plan, err := c.prepare(ctx, inputFile)
+	if err != nil {
+		return err
+	}
+	job, outputFile := plan.job, plan.job.Output
+	res.describe(plan)
+	if job.Profile.Name != c.profile.Name {
+		fmt.Fprintf(c.display, "Encoding profile for %s: %s\n", inputFile, job.Profile.Name)
+	}
+	fmt.Fprintf(c.display, "Selected tracks for %s: %s\n", inputFile, job.Selection)
+	log.Printf("Decision for %s: %s\n", inputFile, job.Decision)
+	if !job.Decision.Remux {
+		v := plan.streams.Video
+		fmt.Fprintf(c.display, "Scaling %s: %dx%d -> %dx%d (%s)\n", inputFile, v.Width, v.Height, plan.scale.Width, plan.scale.Height, plan.scale.Reason)
+	}
+
+	// Файл от прошлого запуска: полный - пропускаем исходник, недописанный - переделываем
+	if !c.force {
+		if rec, ok := c.convertedBefore(res.hash, job.Profile.Name); ok {
+			fmt.Fprintf(c.display, "File %s was converted to %s on %s, skipping\n", inputFile, rec.Output, rec.Time.Local().Format("2006-01-02 15:04"))
+			res.output = rec.Output
+			res.outputSize = rec.OutputSize
+			return errAlreadyConverted
+		}
+		state, reason, err := u.CheckOutput(ctx, outputFile, job.Expected())
+		if err != nil {
+			return err
+		}
+		switch state {
+		case u.OutputValid:
+			fmt.Fprintf(c.display, "File %s is already converted to %s, skipping\n", inputFile, outputFile)
+			res.outputSize = fileSize(outputFile)
+			return errAlreadyConverted
+		case u.OutputIncomplete:
+			// старый файл заменится новым только после успешного кодирования
+			log.Printf("Output %s is incomplete (%s), converting again\n", outputFile, reason)
+		}
+	}
+
+	// время начала конвертации
+	start := time.Now()
+	// Выполняем конвертацию
+	var last u.Progress
+	err = u.ConvertFile(ctx, c.ffmpegPath, job, func(p u.Progress) {
+		last = p
+		c.display.Update(p)
+	})
+	c.display.Remove(inputFile)
+	res.elapsed = time.Since(start)
+	res.frames = last.Frame
+	if err != nil {
+		return err
+	}
+	res.outputSize = fileSize(outputFile)
+	// Calculate the elapsed time
+	hours, minutes, seconds := calculateTime(start)
+	// Format and print the elapsed time
+	fmt.Fprintf(c.display, "File %s was successfully converted to %s in %02d:%02d:%02d\n", inputFile, outputFile, hours, minutes, seconds)
+	return nil
+}
+
+// Обрабатываем файл, повторяя попытку после ошибок кодирования.
+// Возвращает итог и признак того, что после этой ошибки нужно остановить весь пакет.
+// acceptCtx отменяется первым сигналом: после него ошибка кодирования уже не повторяется
+func (c *converter) processWithRetries(ctx, acceptCtx context.Context, inputFile string, retries int) (fileResult, bool) {
+	res := fileResult{file: inputFile}
+	// хэш нужен истории, а исходник между попытками не меняется: считаем один раз
+	if c.history != nil {
+		hash, err := u.FileHash(inputFile)
+		if err != nil {
+			log.Printf("ERROR: %s (%s)\n", err, actionSkip)
+			return res.finish(statusFailed, err), false
+		}
+		res.hash = hash
+	}
+	for attempt := 0; ; attempt++ {
+		err := c.processFile(ctx, inputFile, &res)
+		if err == nil {
+			return res.finish(statusDone, nil), false
+		}
+		if errors.Is(err, errAlreadyConverted) {
+			return res.finish(statusSkipped, nil), false
+		}
+		var verifyErr *u.VerifyError
+		if errors.As(err, &verifyErr) {
+			log.Printf("ERROR: %s, source %s is kept\n", err, inputFile)
+			return res.finish(statusVerifyFailed, err), false
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Conversion of %s is cancelled, partial output removed\n", inputFile)
+			return res.finish(statusCancelled, err), true
+		}
+
+		action := actionFor(err)
+		// после первого Ctrl+C идущие файлы доделываются, но заново не начинаются
+		if action == actionRetry && (attempt >= retries || acceptCtx.Err() != nil) {
+			action = actionSkip
+		}
+		log.Printf("ERROR: %s (%s)\n", err, action)
+
+		switch action {
+		case actionRetry:
+			continue
+		case actionAbort:
+			return res.finish(statusFailed, err), true
+		default:
+			return res.finish(statusFailed, err), false
+		}
+	}
+}
+
+// Исходник с таким хэшем уже сконвертирован в этом профиле, и результат на месте.
+// Так узнаются и переименованные или перенесенные исходники, и результаты,
+// которые лежат не там, куда их положил бы нынешний шаблон имени
+func (c *converter) convertedBefore(hash, profile string) (u.HistoryRecord, bool) {
+	if c.history == nil || hash == "" {
+		return u.HistoryRecord{}, false
+	}
+	rec, ok := c.history.Converted(hash, profile)
+	if !ok || rec.OutputSize == 0 || fileSize(rec.Output) != rec.OutputSize {
+		return u.HistoryRecord{}, false
+	}
+	return rec, true
+}
+
+// Записываем задачу в историю. Пропущенные и не начатые файлы не записываем:
+// задачи по ним не было
+func (c *converter) record(res fileResult) {
+	if c.history == nil || res.status == statusSkipped || res.status == statusNotStarted {
+		return
+	}
+	rec := u.HistoryRecord{
+		Time:       time.Now().UTC(),
+		Input:      absPath(res.file),
+		Hash:       res.hash,
+		Profile:    res.profile,
+		Status:     res.status.String(),
+		Elapsed:    round2(res.elapsed.Seconds()),
+		SourceSize: res.sourceSize,
+		OutputSize: res.outputSize,
+	}
+	if res.output != "" {
+		rec.Output = absPath(res.output)
+	}
+	if res.err != nil {
+		rec.Error = res.err.Error()
+	}
+	if name, err := u.ParseEpisodeName(res.file); err == nil {
+		rec.Show = name.Show
+	}
+	if err := c.history.Add(rec); err != nil {
+		log.Printf("ERROR: %s\n", err)
+	}
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Размер файла, 0 - если его нет
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Указывают ли пути на один и тот же файл
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
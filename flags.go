@@ -0,0 +1,391 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	u "video-converter/utils"
+)
+
+// Список через запятую: -ext mkv,mp4
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(str string) error {
+	*l = splitList(str)
+	return nil
+}
+
+// Размер файла: -min-size 700M
+type sizeFlag int64
+
+func (s *sizeFlag) String() string {
+	if s == nil || *s == 0 {
+		return ""
+	}
+	return fmt.Sprint(int64(*s))
+}
+
+func (s *sizeFlag) Set(str string) error {
+	n, err := u.ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+// Шаблон пути результата: проверяется при разборе флагов, "library" - раскладка библиотеки
+type layoutFlag string
+
+func (l *layoutFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return string(*l)
+}
+
+func (l *layoutFlag) Set(str string) error {
+	if _, err := u.ParseLayout(str); err != nil {
+		return err
+	}
+	*l = layoutFlag(str)
+	return nil
+}
+
+// Группа флагов, которые можно задать и в файле конфигурации
+type settingsGroup interface {
+	// Переносим в s флаги, заданные явно: они важнее любого файла
+	apply(s *u.Settings)
+}
+
+// Вызываем fn для каждого флага, заданного явно
+func visitSet(fs *flag.FlagSet, fn func(name string)) {
+	fs.Visit(func(f *flag.Flag) { fn(f.Name) })
+}
+
+// Флаг файла конфигурации
+type configFlags struct {
+	path string
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "config", configFile, "файл конфигурации; настройки из "+u.DirConfigName+" в каталогах сериалов важнее него")
+}
+
+// Загружаем конфигурацию, флаги групп groups важнее нее
+func (f *configFlags) load(groups ...settingsGroup) (*u.Config, error) {
+	cfg, err := u.LoadConfig(f.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		g.apply(&cfg.CommandLine)
+	}
+	return cfg, nil
+}
+
+// Флаги выбора дорожек
+type selectFlags struct {
+	fs          *flag.FlagSet
+	audioLangs  string
+	subsLangs   string
+	maxPerLang  int
+	keepForced  bool
+	keepComment bool
+}
+
+func (f *selectFlags) register(fs *flag.FlagSet) {
+	def := u.DefaultSettings()
+	f.fs = fs
+	fs.StringVar(&f.audioLangs, "audio-langs", *def.AudioLangs, "предпочтительные языки аудио в порядке приоритета")
+	fs.StringVar(&f.subsLangs, "sub-langs", *def.SubsLangs, "предпочтительные языки субтитров в порядке приоритета")
+	fs.IntVar(&f.maxPerLang, "max-per-lang", *def.MaxPerLang, "сколько дорожек одного языка оставлять, 0 - все")
+	fs.BoolVar(&f.keepForced, "keep-forced", *def.KeepForced, "оставлять форсированные субтитры отдельной дорожкой")
+	fs.BoolVar(&f.keepComment, "keep-commentary", *def.KeepCommentary, "оставлять аудиокомментарии отдельной дорожкой")
+}
+
+func (f *selectFlags) apply(s *u.Settings) {
+	visitSet(f.fs, func(name string) {
+		switch name {
+		case "audio-langs":
+			s.AudioLangs = &f.audioLangs
+		case "sub-langs":
+			s.SubsLangs = &f.subsLangs
+		case "max-per-lang":
+			s.MaxPerLang = &f.maxPerLang
+		case "keep-forced":
+			s.KeepForced = &f.keepForced
+		case "keep-commentary":
+			s.KeepCommentary = &f.keepComment
+		}
+	})
+}
+
+// Флаги профиля кодирования
+type profileFlags struct {
+	fs      *flag.FlagSet
+	path    string
+	name    string
+	noRemux bool
+}
+
+func (f *profileFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.path, "profiles", profilesFile, "файл с профилями кодирования, по умолчанию - указанный в конфигурации или "+profilesFile)
+	fs.StringVar(&f.name, "profile", "", "профиль кодирования, по умолчанию - из конфигурации или указанный в файле профилей")
+	fs.BoolVar(&f.noRemux, "no-remux", false, "всегда перекодировать видео, даже если оно уже соответствует профилю")
+}
+
+func (f *profileFlags) apply(s *u.Settings) {
+	visitSet(f.fs, func(name string) {
+		if name == "profile" {
+			s.Profile = &f.name
+		}
+	})
+}
+
+// Загружаем профили: файл из -profiles, если он задан, иначе из конфигурации
+func (f *profileFlags) load(cfg *u.Config) (u.Profiles, error) {
+	path := f.path
+	if cfg.Profiles != "" {
+		path = cfg.Profiles
+		visitSet(f.fs, func(name string) {
+			if name == "profiles" {
+				path = f.path
+			}
+		})
+	}
+	return u.LoadProfiles(path)
+}
+
+// Флаги поиска исходных файлов
+type discoverFlags struct {
+	extensions listFlag
+	include    listFlag
+	exclude    listFlag
+	minSize    sizeFlag
+	keepExtras bool
+}
+
+func (f *discoverFlags) register(fs *flag.FlagSet) {
+	f.extensions = append(listFlag{}, u.DefaultExtensions...)
+	fs.Var(&f.extensions, "ext", "расширения исходных файлов через запятую, например `mkv,mp4`; регистр не важен")
+	fs.Var(&f.include, "include", "брать только файлы, подходящие под glob-маски через запятую, например `*.mkv,Season*/*`")
+	fs.Var(&f.exclude, "exclude", "пропускать файлы и каталоги, подходящие под glob-маски через запятую, например `Extras,*.ts`")
+	fs.Var(&f.minSize, "min-size", "пропускать файлы меньше этого размера, например `100M`")
+	fs.BoolVar(&f.keepExtras, "keep-extras", false, "не пропускать файлы и каталоги sample и trailer")
+}
+
+// Ищем исходники в paths, без путей - в текущем каталоге. Каталоги skipDirs не обходятся
+func (f *discoverFlags) discover(paths []string, skipDirs ...string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	skip := make([]string, 0, len(skipDirs))
+	for _, dir := range skipDirs {
+		if dir != "" {
+			skip = append(skip, dir)
+		}
+	}
+	return u.Discover(paths, u.DiscoverOptions{
+		Extensions: f.extensions,
+		Include:    f.include,
+		Exclude:    f.exclude,
+		MinSize:    int64(f.minSize),
+		KeepExtras: f.keepExtras,
+		SkipDirs:   skip,
+	})
+}
+
+// Флаги размещения результата
+type outputFlags struct {
+	fs     *flag.FlagSet
+	dir    string
+	layout layoutFlag
+}
+
+func (f *outputFlags) register(fs *flag.FlagSet) {
+	f.fs = fs
+	fs.StringVar(&f.dir, "output-dir", "", "каталог для результатов, по умолчанию - рядом с исходником")
+	fs.Var(&f.layout, "layout", "шаблон пути результата относительно -output-dir; `library` - раскладка библиотеки \""+u.LibraryLayout+"\"")
+}
+
+func (f *outputFlags) apply(s *u.Settings) {
+	visitSet(f.fs, func(name string) {
+		switch name {
+		case "output-dir":
+			s.OutputDir = &f.dir
+		case "layout":
+			layout := string(f.layout)
+			s.Layout = &layout
+		}
+	})
+}
+
+// Сколько файлов кодировать одновременно и сколько потоков дать каждому ffmpeg
+type scheduleFlags struct {
+	jobs    int
+	threads int
+}
+
+func (f *scheduleFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.jobs, "jobs", 0, "сколько файлов кодировать одновременно, 0 - по числу ядер и профилю")
+	fs.IntVar(&f.threads, "threads", 0, "сколько потоков дать одному ffmpeg, 0 - по числу ядер и профилю")
+}
+
+// Делим ядра этой машины между заданиями профиля
+func (f *scheduleFlags) schedule(profile u.Profile) u.Schedule {
+	return u.PlanSchedule(runtime.NumCPU(), profile, f.jobs, f.threads)
+}
+
+// Исходники в paths без результатов прошлых запусков. Каталоги результатов не обходятся,
+// а output_dir в overrides и .video-converter.yaml у каждого файла свой, поэтому сначала
+// находим файлы, по их настройкам узнаем все каталоги результатов и обходим paths заново.
+// Результаты, разложенные по шаблону рядом с исходниками, узнаются по истории
+func (f *discoverFlags) sources(paths []string, cfg *u.Config, history *u.History) ([]string, error) {
+	global := *cfg.Global().OutputDir
+	files, err := f.discover(paths, global)
+	if err != nil {
+		return nil, err
+	}
+	if dirs := cfg.OutputDirs(files); len(dirs) > 1 || len(dirs) == 1 && dirs[0] != global {
+		if files, err = f.discover(paths, dirs...); err != nil {
+			return nil, err
+		}
+	}
+	if history == nil {
+		return files, nil
+	}
+	res := files[:0]
+	for _, file := range files {
+		if !history.IsOutput(file) {
+			res = append(res, file)
+		}
+	}
+	return res, nil
+}
+
+// Файл истории конвертаций
+type historyFlags struct {
+	path string
+}
+
+func (f *historyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "history", defaultHistoryPath(), "файл истории конвертаций, пусто - без истории")
+}
+
+// История из -history, nil - если она выключена
+func (f *historyFlags) open() (*u.History, error) {
+	if f.path == "" {
+		return nil, nil
+	}
+	history, err := u.OpenHistory(f.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range history.Skipped() {
+		log.Print(err)
+	}
+	return history, nil
+}
+
+// История общая для всех каталогов, поэтому лежит в каталоге настроек пользователя
+func defaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "video-converter", historyFile)
+}
+
+// Отчеты о пакете по умолчанию пишутся рядом с историей, после каждого запуска
+type reportFlags struct {
+	dir string
+	off bool
+}
+
+func (f *reportFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dir, "report-dir", defaultReportDir(), "каталог для отчетов о пакете в JSON и CSV, пусто - не писать")
+	fs.BoolVar(&f.off, "no-report", false, "не писать отчет о пакете")
+}
+
+// Каталог для отчета, пустая строка - отчет выключен
+func (f *reportFlags) target() string {
+	if f.off {
+		return ""
+	}
+	return f.dir
+}
+
+func defaultReportDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "video-converter", reportsDir)
+}
+
+// Флаги подкоманд, которые составляют задания: convert, plan, verify
+type jobFlags struct {
+	config   configFlags
+	sel      selectFlags
+	profile  profileFlags
+	discover discoverFlags
+	output   outputFlags
+	history  historyFlags
+}
+
+func (f *jobFlags) register(fs *flag.FlagSet) {
+	f.config.register(fs)
+	f.profile.register(fs)
+	f.sel.register(fs)
+	f.output.register(fs)
+	f.discover.register(fs)
+	f.history.register(fs)
+}
+
+// Загружаем конфигурацию и профили, ищем исходники в paths
+func (f *jobFlags) setup(paths []string) (*converter, []string, error) {
+	cfg, err := f.config.load(&f.sel, &f.profile, &f.output)
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, err := f.profile.load(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	global := cfg.Global()
+	profile, err := profiles.Get(*global.Profile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, err := f.history.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := f.discover.sources(paths, cfg, history)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conv := &converter{
+		config:   cfg,
+		profiles: profiles,
+		profile:  profile,
+		noRemux:  f.profile.noRemux,
+		history:  history,
+	}
+	return conv, files, nil
+}
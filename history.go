@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	u "video-converter/utils"
+)
+
+func runHistory(args []string) int {
+	fs := newFlagSet("history")
+	var hf historyFlags
+	hf.register(fs)
+	status := fs.String("status", "", "только задачи с этим итогом: done, failed, verify failed, cancelled")
+	since := fs.String("since", "", "только задачи за период: `7d`, 36h или с даты 2006-01-02")
+	show := fs.String("show", "", "только серии сериала: часть названия или пути исходника")
+	limit := fs.Int("limit", 0, "только последние N задач, 0 - все")
+	jsonOut := fs.Bool("json", false, "вывести задачи в JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		fs.Usage()
+		return exitUsage
+	}
+
+	filter := u.HistoryFilter{Status: *status, Show: *show}
+	var err error
+	if filter.Since, err = u.ParseSince(*since, time.Now()); err != nil {
+		log.Print(err)
+		return exitUsage
+	}
+	if hf.path == "" {
+		log.Print("history is disabled, set -history")
+		return exitError
+	}
+	history, err := hf.open()
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	records := history.Query(filter)
+	if *limit > 0 && len(records) > *limit {
+		records = records[len(records)-*limit:]
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(records); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		return exitOK
+	}
+	printHistory(os.Stdout, records)
+	return exitOK
+}
+
+// Задачи по одной в строке, у неудачных вместо результата - ошибка:
+//
+//	TIME              STATUS  PROFILE  ELAPSED   INPUT                       RESULT
+//	2026-10-18 03:12  done    hevc720  00:41:07  /tv/Show/Show S01E01.mkv    /tv/Show/Show S01E01.720p.H265.mkv
+//	2026-10-18 03:53  failed  hevc720  00:00:02  /tv/Show/Show S01E02.mkv    ffmpeg exited with status 1
+func printHistory(w io.Writer, records []u.HistoryRecord) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No jobs found")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSTATUS\tPROFILE\tELAPSED\tINPUT\tRESULT")
+	for _, rec := range records {
+		result := rec.Output
+		if rec.Error != "" {
+			// у ошибок ffmpeg дальше идет хвост его вывода
+			result, _, _ = strings.Cut(rec.Error, "\n")
+		}
+		elapsed := time.Duration(rec.Elapsed * float64(time.Second))
+		hours, minutes, seconds := int(elapsed.Hours()), int(elapsed.Minutes())%60, int(elapsed.Seconds())%60
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%02d:%02d:%02d\t%s\t%s\n", rec.Time.Local().Format("2006-01-02 15:04"),
+			rec.Status, rec.Profile, hours, minutes, seconds, rec.Input, result)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d jobs\n", len(records))
+}
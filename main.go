@@ -1,42 +1,238 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 	u "video-converter/utils"
 )
 
 const (
-	fileExt = ".mkv"
+	profilesFile = "profiles.yaml"
+	configFile   = "video-converter.yaml"
+	historyFile  = "history.jsonl"
+	reportsDir   = "reports"
 )
 
+// Коды выхода: по ним cron и скрипты понимают, чем закончился запуск
+const (
+	exitOK          = 0   // все файлы обработаны
+	exitFailed      = 1   // часть файлов не обработана или не прошла проверку
+	exitUsage       = 2   // неверные флаги, аргументы или подкоманда
+	exitError       = 3   // запуск невозможен: нет ffmpeg, ошибка в профилях, нет входного пути
+	exitInterrupted = 130 // остановлено по SIGINT/SIGTERM
+)
+
+// Подкоманда: имя, аргументы для справки, описание и запуск с аргументами после имени
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(args []string) int
+}
+
+func commandList() []command {
+	return []command{
+		{"convert", "[flags] [path ...]", "конвертировать видеофайлы по профилю (по умолчанию)", runConvert},
+		{"probe", "[flags] [path ...]", "вывести дорожки файлов так, как их видит конвертер", runProbe},
+		{"plan", "[flags] [path ...]", "показать, что будет сделано с файлами, и команды ffmpeg, ничего не меняя (то же, что convert -dry-run)", runPlan},
+		{"rename", "[flags] [path ...]", "переименовать исходники по разобранному имени серии без конвертации", runRename},
+		{"verify", "[flags] [path ...]", "проверить готовые файлы для исходников", runVerify},
+		{"config", "[flags] [path ...]", "показать итоговые настройки: общие и для каждого файла", runConfig},
+		{"history", "[flags]", "показать историю конвертаций: ошибки за период, серии сериала", runHistory},
+	}
+}
+
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		// без подкоманды и аргументов - convert в текущем каталоге, как раньше
+		return runConvert(args)
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if cmd, ok := findCommand(args[1]); ok {
+				return cmd.run([]string{"-h"})
+			}
+		}
+		usage(os.Stdout)
+		return exitOK
+	}
+	if cmd, ok := findCommand(args[0]); ok {
+		return cmd.run(args[1:])
+	}
+
+	// флаги или пути без подкоманды - convert, как раньше
+	if _, err := os.Stat(args[0]); err == nil || strings.HasPrefix(args[0], "-") {
+		return runConvert(args)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commandList() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [path ...]\n\nCommands:\n", programName())
+	for _, cmd := range commandList() {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintf(w, "\nКаталоги обходятся рекурсивно, без путей - текущий каталог.\n")
+	fmt.Fprintf(w, "Флаги подкоманды: %s <command> -h\n", programName())
+	fmt.Fprintf(w, "\nExit codes: %d - ok, %d - some files failed, %d - usage error, %d - can't start, %d - interrupted\n",
+		exitOK, exitFailed, exitUsage, exitError, exitInterrupted)
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// Набор флагов подкоманды: ошибки разбора не завершают программу, а возвращаются из parseFlags
+func newFlagSet(name string) *flag.FlagSet {
+	cmd, _ := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n%s\n\nFlags:\n", programName(), cmd.name, cmd.args, cmd.short)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Разбираем флаги подкоманды. ok == false - нужно сразу выйти с кодом code: -h или ошибка в флагах
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	err := fs.Parse(args)
+	switch {
+	case err == nil:
+		return exitOK, true
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK, false
+	default:
+		return exitUsage, false
+	}
+}
+
+// Контекст, который отменяется по SIGINT/SIGTERM: для подкоманд, где нечего дожидаться
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func runConvert(args []string) int {
+	fs := newFlagSet("convert")
+	var jf jobFlags
+	jf.register(fs)
+	force := fs.Bool("force", false, "перекодировать файлы, даже если результат прошлого запуска уже есть")
+	verifyDecode := fs.Bool("verify-decode", false, "после кодирования полностью декодировать результат, чтобы найти битые кадры")
+	retries := fs.Int("retries", 1, "сколько раз повторять кодирование после ошибки ffmpeg")
+	dryRun := fs.Bool("dry-run", false, "ничего не кодировать, только показать план и команды ffmpeg")
+	jsonOut := fs.Bool("json", false, "с -dry-run: вывести план в JSON")
+	var rf reportFlags
+	var sf scheduleFlags
+	rf.register(fs)
+	sf.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
 	startProgram := time.Now()
 
-	// Получаем все файлы в текущем каталоге с расширением .mkv
-	files := u.GetFiles(fileExt)
+	// Загружаем настройки и профили, ищем видеофайлы в указанных файлах и каталогах
+	conv, files, err := jf.setup(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	conv.force = *force
+	if *dryRun {
+		return planFiles(conv, files, &sf, *jsonOut)
+	}
+	if path := conv.config.Path(); path != "" {
+		fmt.Printf("Config: %s\n", path)
+	}
+	fmt.Printf("Encoding profile: %s\n", conv.profile.Name)
+	fmt.Printf("Found %d files\n", len(files))
 
 	// Получаем путь к ffmpeg
-	ffmpegPath := u.Ffmpeg()
+	ffmpegPath, err := u.Ffmpeg()
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
 	fmt.Printf("FFMPEG = %s\n", ffmpegPath)
 
 	// Определяем количество доступных ядер процессора
-	numCores := runtime.NumCPU()
-	fmt.Printf("Number of CPU cores: %d\n", numCores)
+	fmt.Printf("Number of CPU cores: %d\n", runtime.NumCPU())
 
-	// Создаем канал с буфером в размере, соответствующем количеству ядер
-	semaphore := make(chan struct{}, numCores)
+	// Делим ядра между одновременными кодированиями, каждый ffmpeg получает свою долю потоков
+	schedule := sf.schedule(conv.profile)
+	fmt.Printf("Schedule: %s\n", schedule)
+
+	// Создаем канал с буфером в размере, соответствующем количеству одновременных заданий
+	semaphore := make(chan struct{}, schedule.Jobs)
 
 	// Используем wait group для ожидания завершения всех горутин
 	var wg sync.WaitGroup
 
-	for _, file := range files {
+	// Прогресс всех файлов: в терминале перерисовывается на месте, иначе - обычными строками.
+	// Сообщения log идут через тот же вывод, чтобы не ломать блок прогресса
+	display := u.NewStdoutDisplay()
+	log.SetOutput(display)
+	defer log.SetOutput(os.Stderr)
+
+	conv.ffmpegPath = ffmpegPath
+	conv.threads = func(profile u.Profile) int {
+		return schedule.ThreadsFor(runtime.NumCPU(), conv.profile, profile, sf.threads)
+	}
+	conv.fullDecode = *verifyDecode
+	conv.display = display
+
+	// acceptCtx отменяется первым сигналом или фатальной ошибкой: новые файлы больше не запускаем.
+	// runCtx отменяется вторым сигналом: идущие кодирования прерываются
+	acceptCtx, stopAccepting := context.WithCancel(context.Background())
+	defer stopAccepting()
+	runCtx, abortRunning := context.WithCancel(context.Background())
+	defer abortRunning()
+	go handleSignals(stopAccepting, abortRunning)
+
+	summary := &batchSummary{}
+
+	for _, inputFile := range files {
+		if acceptCtx.Err() != nil {
+			summary.add(fileResult{file: inputFile, status: statusNotStarted})
+			continue
+		}
+
+		// Захватываем слот в канале или выходим из ожидания по сигналу
+		select {
+		case semaphore <- struct{}{}:
+		case <-acceptCtx.Done():
+			summary.add(fileResult{file: inputFile, status: statusNotStarted})
+			continue
+		}
 		wg.Add(1)
-		semaphore <- struct{}{} // Захватываем слот в канале
 
 		go func(inputFile string) {
 			defer func() {
@@ -44,57 +240,56 @@ func main() {
 				wg.Done()
 			}()
 
-			// получаем новое имя для перeкодированного файла
-			outputFile, err := u.SplitFileNameByPattern(inputFile)
-			if err != nil {
-				log.Printf("ERROR: patern for file %s hasn't found", inputFile)
-				return
+			res, abort := conv.processWithRetries(runCtx, acceptCtx, inputFile, *retries)
+			summary.add(res)
+			conv.record(res)
+			if abort {
+				stopAccepting()
 			}
-
-			fmt.Printf("Processing file: %s\n", inputFile)
-
-			// Получаем информацию о потоках аудио и субтитров с помощью ffprobe
-			streams := u.GetStreamsInfo(inputFile)
-
-			// Получаем индексы для рус/англ аудиопотока и субтитров
-			// TODO: убрать магические строки
-			russianAudioIndex := strconv.Itoa(streams.Get("rusAudio").Index)
-			englishAudioIndex := strconv.Itoa(streams.Get("engAudio").Index)
-			russianSubtitleIndex := strconv.Itoa(streams.Get("rusSubs").Index)
-			englishSubtitleIndex := strconv.Itoa(streams.Get("engSubs").Index)
-
-			// fmt.Printf("russianAudioIndex = %s\n", russianAudioIndex)
-			// fmt.Printf("englishAudioIndex = %s\n", englishAudioIndex)
-			// fmt.Printf("russianSubtitleIndex = %s\n", russianSubtitleIndex)
-			// fmt.Printf("englishSubtitleIndex = %s\n", englishSubtitleIndex)
-
-			// время начала конвертации
-			start := time.Now()
-			// Выполняем конвертацию
-			err = u.ConvertFile(
-				ffmpegPath,
-				inputFile,
-				outputFile,
-				russianAudioIndex,
-				englishAudioIndex,
-				russianSubtitleIndex,
-				englishSubtitleIndex,
-			)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-			// Calculate the elapsed time
-			hours, minutes, seconds := calculateTime(start)
-			// Format and print the elapsed time
-			fmt.Printf("File %s was successfully converted to %s in %02d:%02d:%02d\n", inputFile, outputFile, hours, minutes, seconds)
-		}(file.Name())
+		}(inputFile)
 	}
 
 	wg.Wait() // Ожидаем завершения всех горутин
+	summary.print(os.Stdout)
+	if dir := rf.target(); dir != "" {
+		rep := summary.report(startProgram, time.Now(), conv.profile.Name)
+		if paths, err := writeReport(dir, rep); err != nil {
+			log.Print(err)
+		} else {
+			fmt.Printf("Report: %s\n", strings.Join(paths, ", "))
+		}
+	}
 	// Calculate the elapsed time whole program
 	hours, minutes, seconds := calculateTime(startProgram)
 	fmt.Printf("Conversion completed in %02d:%02d:%02d\n", hours, minutes, seconds)
+	return summary.exitCode()
+}
+
+// Первый SIGINT/SIGTERM - дожидаемся идущих кодирований, но новые не начинаем,
+// второй - прерываем и их
+func handleSignals(stopAccepting, abortRunning context.CancelFunc) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	<-signals
+	log.Println("Stopping: running conversions will be finished, press Ctrl+C again to abort them")
+	stopAccepting()
+
+	<-signals
+	log.Println("Aborting running conversions")
+	abortRunning()
+	signal.Stop(signals)
+}
+
+// "a, b,,c" => [a b c]
+func splitList(str string) []string {
+	res := make([]string, 0)
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
 }
 
 func calculateTime(start time.Time) (int, int, int) {
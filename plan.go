@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	u "video-converter/utils"
+)
+
+func runPlan(args []string) int {
+	fs := newFlagSet("plan")
+	var jf jobFlags
+	var sf scheduleFlags
+	jf.register(fs)
+	sf.register(fs)
+	force := fs.Bool("force", false, "считать, что готовые файлы прошлых запусков будут перекодированы")
+	jsonOut := fs.Bool("json", false, "вывести план в JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	conv, files, err := jf.setup(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	conv.force = *force
+	return planFiles(conv, files, &sf, *jsonOut)
+}
+
+// Что convert сделает с одним файлом. Для -json выводится как есть
+type planEntry struct {
+	Input       string   `json:"input"`
+	Output      string   `json:"output,omitempty"`
+	Action      string   `json:"action"` // encode, remux, skip или error
+	Reason      string   `json:"reason,omitempty"`
+	Profile     string   `json:"profile,omitempty"`
+	Source      string   `json:"source_resolution,omitempty"`
+	Target      string   `json:"output_resolution,omitempty"`
+	Audio       []string `json:"audio,omitempty"`
+	Subs        []string `json:"subs,omitempty"`
+	OutputState string   `json:"output_state,omitempty"`
+	StateReason string   `json:"output_state_reason,omitempty"`
+	Command     []string `json:"command,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// Прогоняем все шаги convert до запуска ffmpeg и выводим план: таблицей или в JSON.
+// ffmpeg не запускается, его путь нужен только для точной командной строки
+func planFiles(conv *converter, files []string, sf *scheduleFlags, jsonOut bool) int {
+	conv.ffmpegPath = "ffmpeg"
+	if path, err := u.Ffmpeg(); err == nil {
+		conv.ffmpegPath = path
+	}
+	schedule := sf.schedule(conv.profile)
+	conv.threads = func(profile u.Profile) int {
+		return schedule.ThreadsFor(runtime.NumCPU(), conv.profile, profile, sf.threads)
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	code := exitOK
+	entries := make([]planEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := conv.planFile(ctx, file)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+			if errors.Is(err, u.ErrToolNotFound) {
+				return exitError
+			}
+			code = exitFailed
+		}
+		entries = append(entries, entry)
+	}
+
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Print(err)
+			return exitError
+		}
+		return code
+	}
+	fmt.Printf("Encoding profile: %s, %d files\n", conv.profile.Name, len(files))
+	printPlan(os.Stdout, entries)
+	return code
+}
+
+// Решения для одного файла. При ошибке запись все равно возвращается - с action "error"
+func (c *converter) planFile(ctx context.Context, inputFile string) (planEntry, error) {
+	entry := planEntry{Input: inputFile}
+	plan, err := c.prepare(ctx, inputFile)
+	if err == nil {
+		err = c.describePlan(ctx, plan, &entry)
+	}
+	if err != nil {
+		entry.Action = "error"
+		entry.Error = err.Error()
+	}
+	return entry, err
+}
+
+func (c *converter) describePlan(ctx context.Context, plan filePlan, entry *planEntry) error {
+	job := plan.job
+	args, err := job.Args()
+	if err != nil {
+		return err
+	}
+
+	// готовый файл от прошлого запуска: convert его пропустит
+	state, reason := u.OutputMissing, ""
+	if !c.force {
+		state, reason, err = c.previousOutput(ctx, job)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := plan.streams.Video
+	entry.Output = job.Output
+	entry.Profile = job.Profile.Name
+	entry.Reason = job.Decision.Reason
+	entry.Source = strconv.Itoa(v.Width) + "x" + strconv.Itoa(v.Height)
+	entry.Target = entry.Source
+	if !job.Decision.Remux {
+		entry.Target = strconv.Itoa(plan.scale.Width) + "x" + strconv.Itoa(plan.scale.Height)
+	}
+	entry.Audio = job.Selection.AudioLabels()
+	entry.Subs = job.Selection.SubsLabels()
+	entry.OutputState = state.String()
+	entry.StateReason = reason
+	entry.Command = append([]string{c.ffmpegPath}, args...)
+
+	switch {
+	case state == u.OutputValid:
+		entry.Action = "skip"
+	case job.Decision.Remux:
+		entry.Action = "remux"
+	default:
+		entry.Action = "encode"
+	}
+	return nil
+}
+
+// Что осталось от прошлых запусков: результат из истории, если исходник уже
+// конвертировался в этом профиле, иначе - файл на месте job.Output
+func (c *converter) previousOutput(ctx context.Context, job u.Job) (u.OutputState, string, error) {
+	if c.history != nil {
+		hash, err := u.FileHash(job.Input)
+		if err != nil {
+			return u.OutputMissing, "", err
+		}
+		if rec, ok := c.convertedBefore(hash, job.Profile.Name); ok {
+			return u.OutputValid, "converted to " + rec.Output + " on " + rec.Time.Local().Format("2006-01-02 15:04"), nil
+		}
+	}
+	return u.CheckOutput(ctx, job.Output, job.Expected())
+}
+
+// Таблица решений и под ней команды ffmpeg по номерам строк:
+//
+//	#  ACTION  PROFILE  VIDEO                TRACKS               INPUT            OUTPUT
+//	1  encode  hevc720  1920x1080->1280x720  a:[rus eng] s:[eng]  Show S01E01.mkv  Show S01E01.720p.H265.mkv
+//
+//	1. encode: codec h264 instead of hevc
+//	   /usr/bin/ffmpeg -hide_banner -nostats ...
+func printPlan(w io.Writer, entries []planEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tACTION\tPROFILE\tVIDEO\tTRACKS\tINPUT\tOUTPUT")
+	for i, e := range entries {
+		if e.Action == "error" {
+			fmt.Fprintf(tw, "%d\t%s\t-\t-\t-\t%s\t-\n", i+1, e.Action, e.Input)
+			continue
+		}
+		video := e.Source + "->" + e.Target
+		if e.Action == "remux" {
+			video = e.Source + " copy"
+		}
+		tracks := "a:[" + strings.Join(e.Audio, " ") + "] s:[" + strings.Join(e.Subs, " ") + "]"
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, e.Action, e.Profile, video, tracks, e.Input, e.Output)
+	}
+	tw.Flush()
+
+	for i, e := range entries {
+		fmt.Fprintln(w)
+		switch {
+		case e.Action == "error":
+			fmt.Fprintf(w, "%d. error: %s\n", i+1, e.Error)
+			continue
+		case e.Action == "skip":
+			if e.StateReason != "" {
+				fmt.Fprintf(w, "%d. skip: %s, -force to convert again\n", i+1, e.StateReason)
+			} else {
+				fmt.Fprintf(w, "%d. skip: already converted, -force to convert again\n", i+1)
+			}
+			continue
+		case e.OutputState == u.OutputIncomplete.String():
+			fmt.Fprintf(w, "%d. %s: %s; output incomplete: %s, convert again\n", i+1, e.Action, e.Reason, e.StateReason)
+		default:
+			fmt.Fprintf(w, "%d. %s: %s\n", i+1, e.Action, e.Reason)
+		}
+		fmt.Fprintf(w, "   %s\n", u.ShellQuote(e.Command...))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	u "video-converter/utils"
+)
+
+// Результат ffprobe одного файла для -json
+type probeResult struct {
+	File    string          `json:"file"`
+	Streams u.AllStreamInfo `json:"streams"`
+}
+
+func runProbe(args []string) int {
+	fs := newFlagSet("probe")
+	jsonOut := fs.Bool("json", false, "вывести результат в JSON")
+	var discFlags discoverFlags
+	discFlags.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	files, err := discFlags.discover(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	code := exitOK
+	results := make([]probeResult, 0, len(files))
+	for _, file := range files {
+		info, err := u.GetStreamsInfo(ctx, file)
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+			if errors.Is(err, u.ErrToolNotFound) {
+				return exitError
+			}
+			code = exitFailed
+			continue
+		}
+		if *jsonOut {
+			results = append(results, probeResult{File: file, Streams: info})
+		} else {
+			printStreams(os.Stdout, file, info)
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Print(err)
+			return exitError
+		}
+	}
+	return code
+}
+
+// Дорожки файла по строке на поток:
+//
+//	Show S01E01.mkv: 58m12s, 4.2 GiB, 9.6 Mb/s
+//	  video 0:v:0 hevc Main 10 3840x2160 23.976 fps 10 bit
+//	  audio 0:a:0 rus eac3 6ch "Dub" main default
+func printStreams(w io.Writer, file string, info u.AllStreamInfo) {
+	fmt.Fprintf(w, "%s: %s, %s, %s\n", file, info.Duration.Round(time.Second), formatSize(info.Size), formatBitRate(info.BitRate))
+
+	if v := info.Video; v.TypeIndex >= 0 {
+		fmt.Fprintf(w, "  video 0:v:%d %s", v.TypeIndex, v.Codec)
+		if v.Profile != "" {
+			fmt.Fprintf(w, " %s", v.Profile)
+		}
+		fmt.Fprintf(w, " %dx%d %.3f fps %d bit", v.Width, v.Height, v.FrameRate, v.BitDepth)
+		if v.DAR != "" {
+			fmt.Fprintf(w, " DAR %s", v.DAR)
+		}
+		fmt.Fprintln(w)
+	} else {
+		fmt.Fprintln(w, "  no video")
+	}
+	for _, a := range info.Audio {
+		fmt.Fprintf(w, "  audio 0:a:%d %s %s %dch%s %s%s\n", a.TypeIndex, langOrUnknown(a.Language), a.Codec, a.Channels,
+			quotedTitle(a.Title), a.Kind, dispositionFlags(a.Disposition))
+	}
+	for _, s := range info.Subs {
+		fmt.Fprintf(w, "  subs  0:s:%d %s %s%s %s%s\n", s.TypeIndex, langOrUnknown(s.Language), s.Codec,
+			quotedTitle(s.Title), s.Kind, dispositionFlags(s.Disposition))
+	}
+}
+
+func langOrUnknown(lang string) string {
+	if lang == "" {
+		return "und"
+	}
+	return lang
+}
+
+func quotedTitle(title string) string {
+	if title == "" {
+		return ""
+	}
+	return fmt.Sprintf(" %q", title)
+}
+
+func dispositionFlags(d u.Disposition) string {
+	var b strings.Builder
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{d.Default, "default"},
+		{d.Forced, "forced"},
+		{d.HearingImpaired, "sdh"},
+		{d.VisualImpaired, "visual-impaired"},
+		{d.Comment, "commentary"},
+	} {
+		if flag.set {
+			b.WriteString(" " + flag.name)
+		}
+	}
+	return b.String()
+}
+
+// 4509715660 => "4.2 GiB"
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < 0 {
+		return "-" + formatSize(-size)
+	}
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// 9600000 => "9.6 Mb/s"
+func formatBitRate(bitRate int64) string {
+	if bitRate <= 0 {
+		return "bitrate unknown"
+	}
+	return fmt.Sprintf("%.1f Mb/s", float64(bitRate)/1e6)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	u "video-converter/utils"
+)
+
+func runRename(args []string) int {
+	fs := newFlagSet("rename")
+	dryRun := fs.Bool("dry-run", false, "только показать новые имена, ничего не переименовывать")
+	var cfgFlags configFlags
+	var discFlags discoverFlags
+	var outFlags outputFlags
+	cfgFlags.register(fs)
+	outFlags.register(fs)
+	discFlags.register(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	cfg, err := cfgFlags.load(&outFlags)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	files, err := discFlags.sources(fs.Args(), cfg, nil)
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+
+	code := exitOK
+	for _, file := range files {
+		newPath, err := renamePath(cfg, file)
+		if err == nil {
+			err = renameFile(file, newPath, *dryRun)
+		}
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+			code = exitFailed
+		}
+	}
+	return code
+}
+
+// Новый путь файла по его настройкам: каталог и шаблон имени могут быть свои у каждого сериала
+func renamePath(cfg *u.Config, file string) (string, error) {
+	settings, err := cfg.Resolve(file)
+	if err != nil {
+		return "", err
+	}
+	opts, err := settings.OutputOptions()
+	if err != nil {
+		return "", err
+	}
+	return u.RenamePath(file, opts)
+}
+
+// Переименовываем или переносим файл, существующий файл не перезаписываем
+func renameFile(oldPath, newPath string, dryRun bool) error {
+	if sameFile(oldPath, newPath) {
+		fmt.Printf("%s: already named\n", oldPath)
+		return nil
+	}
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("can't rename %s: %s already exists", oldPath, newPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Ошибка при проверке файла %s: %w", newPath, err)
+	}
+
+	fmt.Printf("%s -> %s\n", oldPath, newPath)
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("Ошибка при создании каталога %s: %w", filepath.Dir(newPath), err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("Ошибка при переименовании файла %s: %w", oldPath, err)
+	}
+	return nil
+}
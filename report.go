@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Отчет о пакете для скриптов и таблиц: сколько места сэкономил каждый запуск
+type batchReport struct {
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Profile  string       `json:"profile"`
+	Files    []reportFile `json:"files"`
+	Totals   reportTotals `json:"totals"`
+}
+
+type reportFile struct {
+	Input      string   `json:"input"`
+	Output     string   `json:"output,omitempty"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	Profile    string   `json:"profile,omitempty"`
+	Action     string   `json:"action,omitempty"` // encode или remux
+	Audio      []string `json:"audio,omitempty"`
+	Subs       []string `json:"subs,omitempty"`
+	SourceSize int64    `json:"source_size"`
+	OutputSize int64    `json:"output_size"`
+	Ratio      float64  `json:"ratio"`       // размер исходника к размеру результата
+	Duration   float64  `json:"duration"`    // длительность исходника, секунды
+	EncodeTime float64  `json:"encode_time"` // секунды
+	FPS        float64  `json:"fps"`         // средняя скорость кодирования
+}
+
+// Итоги считаются только по файлам, сконвертированным в этом запуске
+type reportTotals struct {
+	Files      int            `json:"files"`
+	Statuses   map[string]int `json:"statuses"`
+	SourceSize int64          `json:"source_size"`
+	OutputSize int64          `json:"output_size"`
+	Saved      int64          `json:"saved"`
+	Ratio      float64        `json:"ratio"`
+	EncodeTime float64        `json:"encode_time"`
+}
+
+// Собираем отчет по результатам пакета
+func (b *batchSummary) report(started, finished time.Time, profile string) batchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rep := batchReport{
+		Started:  started,
+		Finished: finished,
+		Profile:  profile,
+		Files:    make([]reportFile, 0, len(b.results)),
+	}
+	statuses := make(map[string]int)
+	for _, r := range b.results {
+		statuses[r.status.String()]++
+		f := reportFile{
+			Input:      r.file,
+			Output:     r.output,
+			Status:     r.status.String(),
+			Profile:    r.profile,
+			Audio:      r.audio,
+			Subs:       r.subs,
+			SourceSize: r.sourceSize,
+			OutputSize: r.outputSize,
+			Ratio:      round2(r.ratio()),
+			Duration:   round2(r.duration.Seconds()),
+			EncodeTime: round2(r.elapsed.Seconds()),
+			FPS:        round2(r.fps()),
+		}
+		if r.err != nil {
+			f.Error = r.err.Error()
+		}
+		if r.output != "" {
+			f.Action = "encode"
+			if r.remux {
+				f.Action = "remux"
+			}
+		}
+		rep.Files = append(rep.Files, f)
+	}
+
+	t := b.totals()
+	rep.Totals = reportTotals{
+		Files:      len(b.results),
+		Statuses:   statuses,
+		SourceSize: t.sourceSize,
+		OutputSize: t.outputSize,
+		Saved:      t.saved(),
+		Ratio:      round2(t.ratio()),
+		EncodeTime: round2(t.elapsed.Seconds()),
+	}
+	return rep
+}
+
+func round2(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
+
+// Пишем отчет в dir в двух видах: video-converter-report-<время>.json и .csv.
+// Возвращает пути записанных файлов
+func writeReport(dir string, rep batchReport) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Ошибка при создании каталога отчета %s: %w", dir, err)
+	}
+	base := filepath.Join(dir, "video-converter-report-"+rep.Started.Format("20060102-150405"))
+
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	jsonPath := base + ".json"
+	if err := os.WriteFile(jsonPath, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("Ошибка при записи отчета %s: %w", jsonPath, err)
+	}
+
+	csvPath := base + ".csv"
+	if err := os.WriteFile(csvPath, []byte(reportCSV(rep)), 0644); err != nil {
+		return nil, fmt.Errorf("Ошибка при записи отчета %s: %w", csvPath, err)
+	}
+	return []string{jsonPath, csvPath}, nil
+}
+
+// Строка на файл и последняя строка TOTAL с итогами пакета
+func reportCSV(rep batchReport) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write([]string{"input", "output", "status", "error", "profile", "action", "audio", "subs",
+		"source_size", "output_size", "ratio", "duration", "encode_time", "fps"})
+	for _, f := range rep.Files {
+		w.Write([]string{f.Input, f.Output, f.Status, f.Error, f.Profile, f.Action,
+			strings.Join(f.Audio, " "), strings.Join(f.Subs, " "),
+			strconv.FormatInt(f.SourceSize, 10), strconv.FormatInt(f.OutputSize, 10),
+			formatFloat(f.Ratio), formatFloat(f.Duration), formatFloat(f.EncodeTime), formatFloat(f.FPS)})
+	}
+	t := rep.Totals
+	statuses := make([]string, 0, len(t.Statuses))
+	for status, n := range t.Statuses {
+		statuses = append(statuses, strconv.Itoa(n)+" "+status)
+	}
+	sort.Strings(statuses)
+	w.Write([]string{"TOTAL", "", strings.Join(statuses, ", "), "", rep.Profile, "", "", "",
+		strconv.FormatInt(t.SourceSize, 10), strconv.FormatInt(t.OutputSize, 10),
+		formatFloat(t.Ratio), "", formatFloat(t.EncodeTime), ""})
+	// ошибка записи в strings.Builder невозможна
+	w.Flush()
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
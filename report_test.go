@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileResultRatioFps(t *testing.T) {
+	tests := []struct {
+		result fileResult
+		ratio  float64
+		fps    float64
+	}{
+		{fileResult{sourceSize: 4000, outputSize: 1000, elapsed: 10 * time.Second, frames: 2400}, 4, 240},
+		{fileResult{sourceSize: 4000, outputSize: 0, elapsed: 10 * time.Second, frames: 2400}, 0, 240}, // результата нет
+		{fileResult{sourceSize: 0, outputSize: 1000}, 0, 0},                                            // размер исходника неизвестен
+		{fileResult{sourceSize: 4000, outputSize: 1000, frames: 2400}, 4, 0},                           // кодирование не запускалось
+		{fileResult{}, 0, 0},
+	}
+	for _, test := range tests {
+		if actual := test.result.ratio(); actual != test.ratio {
+			t.Errorf("%+v: ожидался ratio %v, получено %v", test.result, test.ratio, actual)
+		}
+		if actual := test.result.fps(); actual != test.fps {
+			t.Errorf("%+v: ожидался fps %v, получено %v", test.result, test.fps, actual)
+		}
+	}
+}
+
+func TestBatchTotals(t *testing.T) {
+	var b batchSummary
+	b.add(fileResult{file: "a.mkv", status: statusDone, sourceSize: 3000, outputSize: 1000, elapsed: time.Minute})
+	b.add(fileResult{file: "b.mkv", status: statusDone, sourceSize: 1000, outputSize: 1000, elapsed: time.Minute})
+	b.add(fileResult{file: "c.mkv", status: statusFailed, sourceSize: 5000, elapsed: time.Minute})
+	b.add(fileResult{file: "d.mkv", status: statusVerifyFailed, sourceSize: 5000, outputSize: 100, elapsed: time.Minute})
+	b.add(fileResult{file: "e.mkv", status: statusSkipped, sourceSize: 5000})
+
+	expected := batchTotals{files: 2, sourceSize: 4000, outputSize: 2000, elapsed: 2 * time.Minute}
+	actual := b.totals()
+	if actual != expected {
+		t.Errorf("Ожидалось %+v, получено %+v", expected, actual)
+	}
+	if actual.saved() != 2000 || actual.ratio() != 2 {
+		t.Errorf("Ожидалось saved 2000 и ratio 2, получено %d и %v", actual.saved(), actual.ratio())
+	}
+
+	rep := b.report(time.Time{}, time.Time{}, "hevc720")
+	if rep.Totals.Files != 5 || rep.Totals.SourceSize != 4000 || rep.Totals.Saved != 2000 || rep.Totals.EncodeTime != 120 {
+		t.Errorf("Итоги отчета должны считаться только по done файлам: %+v", rep.Totals)
+	}
+
+	var empty batchSummary
+	if totals := empty.totals(); totals != (batchTotals{}) || totals.ratio() != 0 {
+		t.Errorf("Ожидались пустые итоги, получено %+v", totals)
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	var b batchSummary
+	b.add(fileResult{file: `/tv/Show, The/Show "Pilot" S01E01.mkv`, output: `/out/Show, The/Show "Pilot" S01E01.720p.H265.mkv`,
+		status: statusDone, profile: "hevc720", audio: []string{"rus", "eng"}, sourceSize: 3000, outputSize: 1000})
+	b.add(fileResult{file: "/tv/b.mkv", status: statusFailed, err: errors.New("ffmpeg failed:\nline 1, line 2")})
+	data := reportCSV(b.report(time.Time{}, time.Time{}, "hevc720"))
+
+	if !strings.Contains(data, `"/tv/Show, The/Show ""Pilot"" S01E01.mkv"`) {
+		t.Errorf("Путь с запятой и кавычками должен быть в кавычках:\n%s", data)
+	}
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("Ошибка при чтении CSV: %v\n%s", err, data)
+	}
+	expected := [][]string{
+		{"input", "output", "status", "error", "profile", "action", "audio", "subs",
+			"source_size", "output_size", "ratio", "duration", "encode_time", "fps"},
+		{`/tv/Show, The/Show "Pilot" S01E01.mkv`, `/out/Show, The/Show "Pilot" S01E01.720p.H265.mkv`, "done", "", "hevc720", "encode", "rus eng", "",
+			"3000", "1000", "3", "0", "0", "0"},
+		{"/tv/b.mkv", "", "failed", "ffmpeg failed:\nline 1, line 2", "", "", "", "", "0", "0", "0", "0", "0", "0"},
+		{"TOTAL", "", "1 done, 1 failed", "", "hevc720", "", "", "", "3000", "1000", "3", "", "0", ""},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Ожидалось %d строк, получено %d:\n%s", len(expected), len(records), data)
+	}
+	for i := range expected {
+		if strings.Join(records[i], "|") != strings.Join(expected[i], "|") {
+			t.Errorf("Строка %d: ожидалось %q, получено %q", i, expected[i], records[i])
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		statuses []fileStatus
+		expected int
+	}{
+		{nil, exitOK},
+		{[]fileStatus{statusDone, statusSkipped}, exitOK},
+		{[]fileStatus{statusDone, statusCancelled, statusNotStarted}, exitInterrupted},
+		{[]fileStatus{statusNotStarted}, exitInterrupted},
+		{[]fileStatus{statusCancelled, statusFailed}, exitFailed}, // ошибки важнее остановки
+		{[]fileStatus{statusVerifyFailed, statusNotStarted}, exitFailed},
+		{[]fileStatus{statusDone, statusVerifyFailed}, exitFailed},
+	}
+	for _, test := range tests {
+		var b batchSummary
+		for _, status := range test.statuses {
+			b.add(fileResult{status: status})
+		}
+		if actual := b.exitCode(); actual != test.expected {
+			t.Errorf("%v: ожидался код %d, получено %d", test.statuses, test.expected, actual)
+		}
+	}
+}
+
+func TestReportFlags(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	config, err := os.UserConfigDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	// по умолчанию отчет пишется рядом с историей
+	def := filepath.Join(config, "video-converter", "reports")
+	if filepath.Dir(def) != filepath.Dir(defaultHistoryPath()) {
+		t.Errorf("Отчеты %s должны лежать рядом с историей %s", def, defaultHistoryPath())
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, def},
+		{[]string{"-report-dir", "reports"}, "reports"},
+		{[]string{"-report-dir", ""}, ""},
+		{[]string{"-no-report"}, ""},
+		{[]string{"-report-dir", "reports", "-no-report"}, ""},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+		var rf reportFlags
+		rf.register(fs)
+		if err := fs.Parse(test.args); err != nil {
+			t.Errorf("%v: неожиданная ошибка: %v", test.args, err)
+			continue
+		}
+		if actual := rf.target(); actual != test.expected {
+			t.Errorf("%v: ожидался каталог %q, получено %q", test.args, test.expected, actual)
+		}
+	}
+
+	// каталога по умолчанию еще нет: он создается при первом отчете
+	var b batchSummary
+	b.add(fileResult{file: "a.mkv", status: statusDone})
+	paths, err := writeReport(def, b.report(time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC), time.Time{}, "hevc720"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if filepath.Dir(path) != def {
+			t.Errorf("Отчет %s записан не в %s", path, def)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Итог обработки одного файла
+type fileStatus int
+
+const (
+	statusDone         fileStatus = iota // сконвертирован
+	statusFailed                         // ошибка, файл пропущен
+	statusCancelled                      // прерван по сигналу
+	statusNotStarted                     // не запускался из-за остановки пакета
+	statusSkipped                        // уже сконвертирован в прошлый раз
+	statusVerifyFailed                   // результат не прошел проверку и удален
+)
+
+func (s fileStatus) String() string {
+	switch s {
+	case statusFailed:
+		return "failed"
+	case statusCancelled:
+		return "cancelled"
+	case statusNotStarted:
+		return "not started"
+	case statusSkipped:
+		return "skipped"
+	case statusVerifyFailed:
+		return "verify failed"
+	default:
+		return "done"
+	}
+}
+
+type fileResult struct {
+	file   string
+	status fileStatus
+	err    error
+
+	// для отчета: заполняется по мере обработки, у необработанных файлов пусто
+	hash       string // FileHash исходника, если ведется история
+	output     string
+	profile    string
+	remux      bool
+	audio      []string
+	subs       []string
+	sourceSize int64
+	outputSize int64
+	duration   time.Duration // длительность исходника
+	elapsed    time.Duration // время кодирования
+	frames     int64         // закодировано кадров
+}
+
+// Запоминаем, что решено для файла
+func (r *fileResult) describe(plan filePlan) {
+	r.output = plan.job.Output
+	r.profile = plan.job.Profile.Name
+	r.remux = plan.job.Decision.Remux
+	r.audio = plan.job.Selection.AudioLabels()
+	r.subs = plan.job.Selection.SubsLabels()
+	r.sourceSize = plan.streams.Size
+	r.duration = plan.streams.Duration
+}
+
+func (r fileResult) finish(status fileStatus, err error) fileResult {
+	r.status = status
+	r.err = err
+	return r
+}
+
+// Во сколько раз уменьшился файл, 0 - если результата нет
+func (r fileResult) ratio() float64 {
+	return compressionRatio(r.sourceSize, r.outputSize)
+}
+
+// Средняя скорость кодирования в кадрах в секунду
+func (r fileResult) fps() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.frames) / r.elapsed.Seconds()
+}
+
+func compressionRatio(source, output int64) float64 {
+	if source <= 0 || output <= 0 {
+		return 0
+	}
+	return float64(source) / float64(output)
+}
+
+// Сколько места сэкономили файлы, сконвертированные в этом запуске
+type batchTotals struct {
+	files      int
+	sourceSize int64
+	outputSize int64
+	elapsed    time.Duration
+}
+
+func (t batchTotals) saved() int64 {
+	return t.sourceSize - t.outputSize
+}
+
+func (t batchTotals) ratio() float64 {
+	return compressionRatio(t.sourceSize, t.outputSize)
+}
+
+// Итоги всего пакета, заполняются из нескольких горутин
+type batchSummary struct {
+	mu      sync.Mutex
+	results []fileResult
+}
+
+func (b *batchSummary) add(r fileResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.results = append(b.results, r)
+}
+
+// Итоги по сконвертированным файлам. Вызывается под b.mu
+func (b *batchSummary) totals() batchTotals {
+	var t batchTotals
+	for _, r := range b.results {
+		if r.status != statusDone {
+			continue
+		}
+		t.files++
+		t.sourceSize += r.sourceSize
+		t.outputSize += r.outputSize
+		t.elapsed += r.elapsed
+	}
+	return t
+}
+
+// Выводим, что сделано и что нет
+func (b *batchSummary) print(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counts := make(map[fileStatus]int)
+	for _, r := range b.results {
+		counts[r.status]++
+	}
+	fmt.Fprintf(w, "Summary: %d done, %d skipped, %d failed, %d verify failed, %d cancelled, %d not started\n",
+		counts[statusDone], counts[statusSkipped], counts[statusFailed], counts[statusVerifyFailed], counts[statusCancelled], counts[statusNotStarted])
+	if t := b.totals(); t.files > 0 {
+		fmt.Fprintf(w, "Saved: %s (%s -> %s, %.2fx)\n", formatSize(t.saved()), formatSize(t.sourceSize), formatSize(t.outputSize), t.ratio())
+	}
+
+	for _, status := range []fileStatus{statusFailed, statusVerifyFailed, statusCancelled, statusNotStarted} {
+		for _, r := range b.results {
+			if r.status != status {
+				continue
+			}
+			if r.err != nil {
+				fmt.Fprintf(w, "  %-13s %s: %v\n", r.status, r.file, r.err)
+			} else {
+				fmt.Fprintf(w, "  %-13s %s\n", r.status, r.file)
+			}
+		}
+	}
+}
+
+// Код выхода по итогам пакета: ошибки важнее остановки по сигналу
+func (b *batchSummary) exitCode() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	code := exitOK
+	for _, r := range b.results {
+		switch r.status {
+		case statusFailed, statusVerifyFailed:
+			return exitFailed
+		case statusCancelled, statusNotStarted:
+			code = exitInterrupted
+		}
+	}
+	return code
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Тип аудиодорожки
+type AudioKind int
+
+const (
+	AudioMain        AudioKind = iota // основная озвучка
+	AudioCommentary                   // комментарии создателей
+	AudioDescription                  // тифлокомментарии для слабовидящих
+)
+
+func (k AudioKind) String() string {
+	switch k {
+	case AudioCommentary:
+		return "commentary"
+	case AudioDescription:
+		return "description"
+	default:
+		return "main"
+	}
+}
+
+// Тип субтитров
+type SubsKind int
+
+const (
+	SubsFull       SubsKind = iota // полные субтитры
+	SubsForced                     // форсированные: только надписи и иностранная речь
+	SubsSDH                        // для слабослышащих
+	SubsCommentary                 // комментарии создателей
+)
+
+func (k SubsKind) String() string {
+	switch k {
+	case SubsForced:
+		return "forced"
+	case SubsSDH:
+		return "sdh"
+	case SubsCommentary:
+		return "commentary"
+	default:
+		return "full"
+	}
+}
+
+// Если в форсированных субтитрах реплик меньше этой доли от полных, то это форсированные
+const forcedSubsRatio = 0.25
+
+var (
+	forcedTitle      = regexp.MustCompile(`(?i)forced|форс|надписи|\bsigns?\b`)
+	sdhTitle         = regexp.MustCompile(`(?i)\bsdh\b|\bcc\b|hearing.impaired|слабослыш|для глухих`)
+	commentaryTitle  = regexp.MustCompile(`(?i)comment|коммент`)
+	descriptionTitle = regexp.MustCompile(`(?i)audio.?description|descriptive|\bAD\b|тифло|для слабовидящих`)
+)
+
+// Определяем тип каждой аудиодорожки по флагам disposition и названию
+func ClassifyAudio(audio Audios) Audios {
+	res := make(Audios, len(audio))
+	copy(res, audio)
+
+	for i := range res {
+		res[i].Kind = classifyAudioTrack(res[i])
+	}
+	return res
+}
+
+func classifyAudioTrack(a AudioInfo) AudioKind {
+	switch {
+	case a.Comment:
+		return AudioCommentary
+	case a.VisualImpaired:
+		return AudioDescription
+	// "тифлокомментарий" тоже содержит "коммент", поэтому описание проверяем раньше
+	case descriptionTitle.MatchString(a.Title):
+		return AudioDescription
+	case commentaryTitle.MatchString(a.Title):
+		return AudioCommentary
+	}
+	return AudioMain
+}
+
+// Сортируем дорожки от лучшей к худшей: основные впереди,
+// среди них - с большим числом каналов, потом с большим битрейтом.
+// При равенстве сохраняется порядок в файле
+func RankAudio(audio Audios) Audios {
+	res := make(Audios, len(audio))
+	copy(res, audio)
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Kind != res[j].Kind {
+			return res[i].Kind < res[j].Kind
+		}
+		if res[i].Channels != res[j].Channels {
+			return res[i].Channels > res[j].Channels
+		}
+		return res[i].BitRate > res[j].BitRate
+	})
+	return res
+}
+
+// Определяем тип каждой дорожки субтитров.
+// Сначала смотрим на флаги disposition, потом на название дорожки,
+// а в конце сравниваем количество реплик с другими дорожками того же языка и вида.
+func ClassifySubs(subs Subs) Subs {
+	res := make(Subs, len(subs))
+	copy(res, subs)
+
+	for i := range res {
+		res[i].Kind = classifySubsTrack(res[i])
+	}
+
+	for i := range res {
+		if res[i].Kind != SubsFull {
+			continue
+		}
+		if ref, ok := subsReference(res, i); ok && isSmallSubs(res[i], ref) {
+			res[i].Kind = SubsForced
+		}
+	}
+
+	return res
+}
+
+func classifySubsTrack(s SubsInfo) SubsKind {
+	switch {
+	case s.Forced:
+		return SubsForced
+	case s.Comment:
+		return SubsCommentary
+	case s.HearingImpaired:
+		return SubsSDH
+	case commentaryTitle.MatchString(s.Title):
+		return SubsCommentary
+	case forcedTitle.MatchString(s.Title):
+		return SubsForced
+	case sdhTitle.MatchString(s.Title):
+		return SubsSDH
+	}
+	return SubsFull
+}
+
+// Субтитры-картинки (PGS, VobSub) на ту же реплику в сотни раз больше текстовых,
+// поэтому сравнивать можно только дорожки одного вида
+var imageSubsCodecs = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+func sameSubsType(a, b SubsInfo) bool {
+	return imageSubsCodecs[a.Codec] == imageSubsCodecs[b.Codec]
+}
+
+// С чем сравнивать дорожку: с самыми большими субтитрами того же языка и того же вида
+// (текст или картинки). Если сравнить не с чем, дорожка считается полной
+func subsReference(subs Subs, i int) (SubsInfo, bool) {
+	var ref SubsInfo
+	found := false
+	lang := NormalizeLang(subs[i].Language)
+	for j, s := range subs {
+		if j == i || NormalizeLang(s.Language) != lang || s.Kind == SubsForced || !sameSubsType(s, subs[i]) {
+			continue
+		}
+		found = true
+		ref.Frames = max64(ref.Frames, s.Frames)
+		ref.Bytes = max64(ref.Bytes, s.Bytes)
+	}
+	return ref, found
+}
+
+// Форсированные субтитры - это несколько десятков реплик против сотен в полных.
+// Количество реплик надежнее размера: размер зависит от разметки и длины строк.
+// По размеру сравниваем, только если количество реплик неизвестно
+func isSmallSubs(s, ref SubsInfo) bool {
+	if s.Frames > 0 && ref.Frames > 0 {
+		return isSmall(s.Frames, ref.Frames)
+	}
+	return isSmall(s.Bytes, ref.Bytes)
+}
+
+func isSmall(value, reference int64) bool {
+	if value <= 0 || reference <= 0 {
+		return false
+	}
+	return float64(value) < float64(reference)*forcedSubsRatio
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
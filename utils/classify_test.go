@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestClassifySubs(t *testing.T) {
+	subs := Subs{
+		{TypeIndex: 0, Language: "rus", Title: "Форсированные", Frames: 40, Bytes: 2000},
+		{TypeIndex: 1, Language: "rus", Title: "Полные", Frames: 610, Bytes: 38000},
+		{TypeIndex: 2, Language: "eng", Title: "English SDH", Frames: 700, Bytes: 40000},
+		{TypeIndex: 3, Language: "eng", Title: "Director's Commentary", Frames: 900, Bytes: 52000},
+		{TypeIndex: 4, Language: "eng", Frames: 35, Bytes: 1900},
+		{TypeIndex: 5, Language: "eng", Frames: 650, Bytes: 37000, Disposition: Disposition{Forced: true}},
+		{TypeIndex: 6, Language: "nor", Frames: 20, Bytes: 900},
+		{TypeIndex: 7, Language: "swe", Bytes: 600},
+		{TypeIndex: 8, Language: "fin"},
+		{TypeIndex: 9, Language: "ger", Disposition: Disposition{HearingImpaired: true}},
+		{TypeIndex: 10, Language: "ger", Title: "Kommentar", Disposition: Disposition{Comment: true}},
+		{TypeIndex: 11, Language: "swe", Bytes: 31000},
+		{TypeIndex: 12, Language: "rus", Frames: 580, Bytes: 7000},
+	}
+	expected := []SubsKind{
+		SubsForced,     // по названию
+		SubsFull,       // по названию ничего не понятно, реплик много
+		SubsSDH,        // по названию
+		SubsCommentary, // по названию
+		SubsForced,     // реплик намного меньше, чем в других eng
+		SubsForced,     // по флагу disposition
+		SubsFull,       // единственные nor: сравнивать не с чем
+		SubsForced,     // реплики неизвестны, размер намного меньше, чем у других swe
+		SubsFull,       // нет статистики
+		SubsSDH,        // по флагу disposition
+		SubsCommentary, // по флагу disposition
+		SubsFull,       // самые большие swe
+		SubsFull,       // размер меньше, но реплик столько же, сколько в полных rus
+	}
+
+	res := ClassifySubs(subs)
+	for i, s := range res {
+		if s.Kind != expected[i] {
+			t.Errorf("Для субтитров %d (%q) ожидался тип %s, получено %s", i, s.Title, expected[i], s.Kind)
+		}
+	}
+	if subs[0].Kind != SubsFull {
+		t.Error("ClassifySubs не должна менять исходный срез")
+	}
+}
+
+// Картинки (PGS) сравниваются только с картинками, текст - только с текстом
+func TestClassifySubsCodecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		subs     Subs
+		expected []SubsKind
+	}{
+		{
+			name: "единственные текстовые rus рядом с большими PGS eng",
+			subs: Subs{
+				{TypeIndex: 0, Language: "eng", Codec: "hdmv_pgs_subtitle", Frames: 1800, Bytes: 30 << 20},
+				{TypeIndex: 1, Language: "rus", Codec: "subrip", Frames: 900, Bytes: 60 << 10},
+			},
+			expected: []SubsKind{SubsFull, SubsFull},
+		},
+		{
+			name: "текст и PGS одного языка",
+			subs: Subs{
+				{TypeIndex: 0, Language: "eng", Codec: "hdmv_pgs_subtitle", Frames: 1800, Bytes: 30 << 20},
+				{TypeIndex: 1, Language: "eng", Codec: "subrip", Frames: 1750, Bytes: 70 << 10},
+				{TypeIndex: 2, Language: "eng", Codec: "hdmv_pgs_subtitle", Frames: 45, Bytes: 700 << 10},
+				{TypeIndex: 3, Language: "eng", Codec: "ass", Frames: 40, Bytes: 3 << 10},
+			},
+			expected: []SubsKind{SubsFull, SubsFull, SubsForced, SubsForced},
+		},
+	}
+	for _, test := range tests {
+		res := ClassifySubs(test.subs)
+		for i, s := range res {
+			if s.Kind != test.expected[i] {
+				t.Errorf("%s: для субтитров %d (%s) ожидался тип %s, получено %s", test.name, i, s.Codec, test.expected[i], s.Kind)
+			}
+		}
+	}
+}
+
+func TestClassifyAudio(t *testing.T) {
+	audio := Audios{
+		{TypeIndex: 0, Language: "eng", Title: "English"},
+		{TypeIndex: 1, Language: "eng", Title: "Commentary by director and cast"},
+		{TypeIndex: 2, Language: "eng", Title: "Audio Description"},
+		{TypeIndex: 3, Language: "rus", Title: "Комментарии переводчика"},
+		{TypeIndex: 4, Language: "rus", Title: "Тифлокомментарий"},
+		{TypeIndex: 5, Language: "eng", Disposition: Disposition{Comment: true}},
+		{TypeIndex: 6, Language: "eng", Disposition: Disposition{VisualImpaired: true}},
+		{TypeIndex: 7, Language: "eng", Title: "English AD"},
+		{TypeIndex: 8, Language: "eng", Title: "Headphones mix"},
+	}
+	expected := []AudioKind{
+		AudioMain,
+		AudioCommentary,
+		AudioDescription,
+		AudioCommentary,
+		AudioDescription,
+		AudioCommentary,
+		AudioDescription,
+		AudioDescription,
+		AudioMain,
+	}
+
+	for i, a := range ClassifyAudio(audio) {
+		if a.Kind != expected[i] {
+			t.Errorf("Для аудио %d (%q) ожидался тип %s, получено %s", i, a.Title, expected[i], a.Kind)
+		}
+	}
+}
+
+func TestRankAudio(t *testing.T) {
+	audio := Audios{
+		{TypeIndex: 0, Kind: AudioCommentary, Channels: 6, BitRate: 640000},
+		{TypeIndex: 1, Channels: 2, BitRate: 192000},
+		{TypeIndex: 2, Channels: 6, BitRate: 384000},
+		{TypeIndex: 3, Channels: 6, BitRate: 640000},
+		{TypeIndex: 4, Channels: 2, BitRate: 192000},
+	}
+	expected := []int{3, 2, 1, 4, 0}
+
+	for i, a := range RankAudio(audio) {
+		if a.TypeIndex != expected[i] {
+			t.Errorf("На месте %d ожидалась дорожка %d, получено %d", i, expected[i], a.TypeIndex)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Тип потока в спецификаторах ffmpeg (0:a:1, -c:s:0)
+type StreamType string
+
+const (
+	StreamVideo    StreamType = "v"
+	StreamAudio    StreamType = "a"
+	StreamSubtitle StreamType = "s"
+)
+
+// Ссылка на поток во входном файле: номер входа, тип и индекс среди потоков этого типа
+type StreamRef struct {
+	Input int
+	Type  StreamType
+	Index int
+}
+
+// "0:a:1"
+func (r StreamRef) String() string {
+	return strconv.Itoa(r.Input) + ":" + string(r.Type) + ":" + strconv.Itoa(r.Index)
+}
+
+// Опция ffmpeg без ведущего дефиса: {"crf", "23"} => -crf 23.
+// Опция с пустым значением выводится без значения: {"y", ""} => -y
+type Option struct {
+	Name  string
+	Value string
+}
+
+// Поток выходного файла
+type OutputStream struct {
+	Source      StreamRef
+	Codec       string   // "copy" или имя кодека
+	Options     []Option // опции кодека
+	Filters     []string // цепочка фильтров
+	Metadata    []Option // метаданные потока: title, language
+	Disposition []string // флаги: default, forced, comment
+}
+
+// Добавляем опцию кодека
+func (s *OutputStream) Set(name, value string) *OutputStream {
+	s.Options = append(s.Options, Option{Name: name, Value: value})
+	return s
+}
+
+// Добавляем фильтры в конец цепочки
+func (s *OutputStream) Filter(filters ...string) *OutputStream {
+	s.Filters = append(s.Filters, filters...)
+	return s
+}
+
+// Добавляем метаданные потока
+func (s *OutputStream) Meta(key, value string) *OutputStream {
+	s.Metadata = append(s.Metadata, Option{Name: key, Value: value})
+	return s
+}
+
+// Добавляем флаги disposition
+func (s *OutputStream) Flag(flags ...string) *OutputStream {
+	s.Disposition = append(s.Disposition, flags...)
+	return s
+}
+
+// Команда ffmpeg с одним выходным файлом.
+// Порядок аргументов всегда один и тот же: глобальные опции, входы,
+// кодеки и опции видео, аудио и субтитров, -map, метаданные, disposition, выход
+type Command struct {
+	Global []Option
+	Inputs []string
+	Video  []*OutputStream
+	Audio  []*OutputStream
+	Subs   []*OutputStream
+	Format string // -f для выходного файла, пустой - по расширению
+	Output string
+}
+
+// Добавляем глобальную опцию
+func (c *Command) SetGlobal(name, value string) *Command {
+	c.Global = append(c.Global, Option{Name: name, Value: value})
+	return c
+}
+
+// Добавляем входной файл и возвращаем его номер
+func (c *Command) AddInput(path string) int {
+	c.Inputs = append(c.Inputs, path)
+	return len(c.Inputs) - 1
+}
+
+// Добавляем поток в выходной файл
+func (c *Command) AddStream(source StreamRef, codec string) *OutputStream {
+	s := &OutputStream{Source: source, Codec: codec}
+	switch source.Type {
+	case StreamVideo:
+		c.Video = append(c.Video, s)
+	case StreamAudio:
+		c.Audio = append(c.Audio, s)
+	case StreamSubtitle:
+		c.Subs = append(c.Subs, s)
+	}
+	return s
+}
+
+// Аргументы для exec.Command
+func (c *Command) Args() []string {
+	res := make([]string, 0)
+
+	for _, o := range c.Global {
+		res = appendOption(res, o.Name, o.Value)
+	}
+	for _, in := range c.Inputs {
+		res = append(res, "-i", in)
+	}
+
+	for i, s := range c.Video {
+		res = s.codecArgs(res, c.videoSpec(i))
+	}
+	for i, s := range c.Audio {
+		res = s.codecArgs(res, string(StreamAudio)+":"+strconv.Itoa(i))
+	}
+	for i, s := range c.Subs {
+		res = s.codecArgs(res, string(StreamSubtitle)+":"+strconv.Itoa(i))
+	}
+
+	streams := c.streams()
+	for _, s := range streams {
+		res = append(res, "-map", s.stream.Source.String())
+	}
+	for _, s := range streams {
+		for _, m := range s.stream.Metadata {
+			res = append(res, "-metadata:s:"+s.spec, m.Name+"="+m.Value)
+		}
+	}
+	for _, s := range streams {
+		if len(s.stream.Disposition) > 0 {
+			res = append(res, "-disposition:"+s.spec, strings.Join(s.stream.Disposition, "+"))
+		}
+	}
+
+	if c.Format != "" {
+		res = append(res, "-f", c.Format)
+	}
+	res = append(res, c.Output)
+
+	return res
+}
+
+// Спецификатор видеопотока. Единственный видеопоток - просто "v",
+// тогда его опции пишутся без спецификатора: -c:v libx265 -crf 23 -vf ...
+func (c *Command) videoSpec(i int) string {
+	if len(c.Video) == 1 {
+		return string(StreamVideo)
+	}
+	return string(StreamVideo) + ":" + strconv.Itoa(i)
+}
+
+type specStream struct {
+	spec   string
+	stream *OutputStream
+}
+
+// Все выходные потоки в порядке записи в файл: видео, аудио, субтитры
+func (c *Command) streams() []specStream {
+	res := make([]specStream, 0, len(c.Video)+len(c.Audio)+len(c.Subs))
+	for i, s := range c.Video {
+		res = append(res, specStream{c.videoSpec(i), s})
+	}
+	for i, s := range c.Audio {
+		res = append(res, specStream{string(StreamAudio) + ":" + strconv.Itoa(i), s})
+	}
+	for i, s := range c.Subs {
+		res = append(res, specStream{string(StreamSubtitle) + ":" + strconv.Itoa(i), s})
+	}
+	return res
+}
+
+func (s *OutputStream) codecArgs(res []string, spec string) []string {
+	res = append(res, "-c:"+spec, s.Codec)
+
+	// у единственного видеопотока опции без спецификатора, у остальных - со своим
+	suffix := ""
+	if spec != string(StreamVideo) {
+		suffix = ":" + spec
+	}
+	for _, o := range s.Options {
+		res = appendOption(res, o.Name+suffix, o.Value)
+	}
+	if len(s.Filters) > 0 {
+		if spec == string(StreamVideo) {
+			res = append(res, "-vf", strings.Join(s.Filters, ","))
+		} else {
+			res = append(res, "-filter:"+spec, strings.Join(s.Filters, ","))
+		}
+	}
+	return res
+}
+
+// Командная строка для вывода пользователю: аргументы с пробелами и спецсимволами
+// берутся в одинарные кавычки, так что строку можно скопировать в sh как есть
+func ShellQuote(args ...string) string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		res[i] = quoteArg(arg)
+	}
+	return strings.Join(res, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range arg {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func appendOption(res []string, name, value string) []string {
+	res = append(res, "-"+name)
+	if value != "" {
+		res = append(res, value)
+	}
+	return res
+}
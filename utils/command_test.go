@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandArgs(t *testing.T) {
+	cmd := &Command{Output: "out.partial", Format: "matroska"}
+	cmd.SetGlobal("hide_banner", "").SetGlobal("y", "")
+	in := cmd.AddInput("in.mkv")
+	ext := cmd.AddInput("in.rus.srt")
+
+	cmd.AddStream(StreamRef{Input: in, Type: StreamVideo, Index: 0}, "libx265").
+		Set("crf", "23").
+		Filter("scale=-2:720")
+	cmd.AddStream(StreamRef{Input: in, Type: StreamAudio, Index: 2}, "aac").
+		Set("b", "192k").
+		Filter("loudnorm").
+		Meta("language", "rus").
+		Flag("default")
+	cmd.AddStream(StreamRef{Input: ext, Type: StreamSubtitle, Index: 0}, "copy").
+		Meta("title", "Forced").
+		Flag("forced", "default")
+
+	expected := []string{
+		"-hide_banner", "-y",
+		"-i", "in.mkv", "-i", "in.rus.srt",
+		"-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
+		"-c:a:0", "aac", "-b:a:0", "192k", "-filter:a:0", "loudnorm",
+		"-c:s:0", "copy",
+		"-map", "0:v:0", "-map", "0:a:2", "-map", "1:s:0",
+		"-metadata:s:a:0", "language=rus", "-metadata:s:s:0", "title=Forced",
+		"-disposition:a:0", "default", "-disposition:s:0", "forced+default",
+		"-f", "matroska", "out.partial",
+	}
+	if args := cmd.Args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, args)
+	}
+}
+
+func TestCommandArgsSeveralVideoStreams(t *testing.T) {
+	cmd := &Command{Output: "out.mkv"}
+	in := cmd.AddInput("in.mkv")
+	cmd.AddStream(StreamRef{Input: in, Type: StreamVideo, Index: 0}, "libx264").Set("crf", "20")
+	cmd.AddStream(StreamRef{Input: in, Type: StreamVideo, Index: 1}, "copy")
+
+	// при нескольких видеопотоках у опций появляется спецификатор
+	expected := []string{
+		"-i", "in.mkv",
+		"-c:v:0", "libx264", "-crf:v:0", "20",
+		"-c:v:1", "copy",
+		"-map", "0:v:0", "-map", "0:v:1",
+		"out.mkv",
+	}
+	if args := cmd.Args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, args)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	actual := ShellQuote("ffmpeg", "-i", "Show S01E01.mkv", "-vf", "scale=-2:720", "-metadata:s:a:0", "title=Director's cut", "")
+	expected := `ffmpeg -i 'Show S01E01.mkv' -vf scale=-2:720 -metadata:s:a:0 'title=Director'\''s cut' ''`
+	if actual != expected {
+		t.Errorf("Ожидалось %s, получено %s", expected, actual)
+	}
+}
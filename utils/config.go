@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Файл настроек в каталоге сериала: важнее общего файла конфигурации
+const DirConfigName = ".video-converter.yaml"
+
+// Настройки, которые задаются в файле конфигурации, в каталогах и флагами.
+// nil - не задано на этом уровне, берется значение уровнем ниже
+type Settings struct {
+	Profile        *string `yaml:"profile"`
+	AudioLangs     *string `yaml:"audio_langs"` // "rus,eng", как во флаге -audio-langs
+	SubsLangs      *string `yaml:"sub_langs"`
+	MaxPerLang     *int    `yaml:"max_per_lang"`
+	KeepForced     *bool   `yaml:"keep_forced"`
+	KeepCommentary *bool   `yaml:"keep_commentary"`
+	OutputDir      *string `yaml:"output_dir"` // относительный путь - от каталога файла настроек
+	Layout         *string `yaml:"layout"`
+}
+
+// Значения по умолчанию: то, что программа делала без файла конфигурации
+func DefaultSettings() Settings {
+	profile, langs, maxPerLang, yes, no, empty := "", "rus,eng", 1, true, false, ""
+	return Settings{
+		Profile:        &profile,
+		AudioLangs:     &langs,
+		SubsLangs:      &langs,
+		MaxPerLang:     &maxPerLang,
+		KeepForced:     &yes,
+		KeepCommentary: &no,
+		OutputDir:      &empty,
+		Layout:         &empty,
+	}
+}
+
+// Одно поле настроек для вывода: имя как в YAML и значение
+type SettingField struct {
+	Name  string
+	Value string
+	set   bool
+}
+
+// Поля настроек по порядку, незаданные - с пустым значением
+func (s Settings) Fields() []SettingField {
+	return []SettingField{
+		stringField("profile", s.Profile),
+		stringField("audio_langs", s.AudioLangs),
+		stringField("sub_langs", s.SubsLangs),
+		{"max_per_lang", formatPtr(s.MaxPerLang, strconv.Itoa), s.MaxPerLang != nil},
+		{"keep_forced", formatPtr(s.KeepForced, strconv.FormatBool), s.KeepForced != nil},
+		{"keep_commentary", formatPtr(s.KeepCommentary, strconv.FormatBool), s.KeepCommentary != nil},
+		stringField("output_dir", s.OutputDir),
+		stringField("layout", s.Layout),
+	}
+}
+
+func stringField(name string, value *string) SettingField {
+	return SettingField{name, formatPtr(value, strconv.Quote), value != nil}
+}
+
+func formatPtr[T any](value *T, format func(T) string) string {
+	if value == nil {
+		return ""
+	}
+	return format(*value)
+}
+
+// Накладываем заданные поля over поверх s
+func (s Settings) merge(over Settings) Settings {
+	mergePtr(&s.Profile, over.Profile)
+	mergePtr(&s.AudioLangs, over.AudioLangs)
+	mergePtr(&s.SubsLangs, over.SubsLangs)
+	mergePtr(&s.MaxPerLang, over.MaxPerLang)
+	mergePtr(&s.KeepForced, over.KeepForced)
+	mergePtr(&s.KeepCommentary, over.KeepCommentary)
+	mergePtr(&s.OutputDir, over.OutputDir)
+	mergePtr(&s.Layout, over.Layout)
+	return s
+}
+
+func mergePtr[T any](dst **T, src *T) {
+	if src != nil {
+		*dst = src
+	}
+}
+
+// Проверяем значения и приводим относительный output_dir к каталогу dir
+func (s *Settings) prepare(dir string) error {
+	if s.MaxPerLang != nil && *s.MaxPerLang < 0 {
+		return fmt.Errorf("max_per_lang не может быть отрицательным: %d", *s.MaxPerLang)
+	}
+	if s.Layout != nil && *s.Layout != "" {
+		if _, err := ParseLayout(*s.Layout); err != nil {
+			return err
+		}
+	}
+	if s.OutputDir != nil && *s.OutputDir != "" && !filepath.IsAbs(*s.OutputDir) {
+		outputDir := filepath.Join(dir, *s.OutputDir)
+		s.OutputDir = &outputDir
+	}
+	return nil
+}
+
+// Настройки для сериалов или каталогов из общего файла конфигурации
+type Override struct {
+	Settings `yaml:",inline"`
+	Path     string `yaml:"path"` // glob каталога исходника или одного из его родителей: "/media/anime/*"
+	Show     string `yaml:"show"` // название сериала из имени файла, регистр не важен
+}
+
+// Подходит ли override для файла: все заданные условия должны совпасть
+func (o Override) matches(file string, show string) bool {
+	if o.Show != "" && !strings.EqualFold(o.Show, show) {
+		return false
+	}
+	if o.Path == "" {
+		return true
+	}
+	for dir := filepath.Dir(file); ; dir = filepath.Dir(dir) {
+		if ok, _ := filepath.Match(o.Path, dir); ok {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+func (o Override) String() string {
+	conditions := make([]string, 0, 2)
+	if o.Path != "" {
+		conditions = append(conditions, fmt.Sprintf("path %q", o.Path))
+	}
+	if o.Show != "" {
+		conditions = append(conditions, fmt.Sprintf("show %q", o.Show))
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// Общий файл конфигурации: глобальные настройки и переопределения по сериалам и каталогам
+type Config struct {
+	Settings  `yaml:",inline"`
+	Profiles  string     `yaml:"profiles"` // файл профилей, относительный путь - от каталога конфигурации
+	Overrides []Override `yaml:"overrides"`
+
+	// Настройки из флагов: важнее всех файлов
+	CommandLine Settings `yaml:"-"`
+
+	path    string
+	mu      sync.Mutex
+	dirs    map[string]*Settings // настройки из DirConfigName по каталогам, nil - файла нет
+	dirErrs map[string]error
+}
+
+// Загружаем файл конфигурации. Если файла нет, возвращаем пустую конфигурацию
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{
+		path:    path,
+		dirs:    make(map[string]*Settings),
+		dirErrs: make(map[string]error),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cfg.path = ""
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении файла конфигурации %s: %w", path, err)
+	}
+	if err := decodeStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("Ошибка при разборе файла конфигурации %s: %w", path, err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Settings.prepare(dir); err != nil {
+		return nil, fmt.Errorf("Ошибка в файле конфигурации %s: %w", path, err)
+	}
+	if cfg.Profiles != "" && !filepath.IsAbs(cfg.Profiles) {
+		cfg.Profiles = filepath.Join(dir, cfg.Profiles)
+	}
+	for i := range cfg.Overrides {
+		o := &cfg.Overrides[i]
+		if o.Path == "" && o.Show == "" {
+			return nil, fmt.Errorf("Ошибка в файле конфигурации %s: в overrides[%d] нет ни path, ни show", path, i)
+		}
+		if o.Path != "" {
+			if !filepath.IsAbs(o.Path) {
+				o.Path = filepath.Join(dir, o.Path)
+			}
+			if _, err := filepath.Match(o.Path, ""); err != nil {
+				return nil, fmt.Errorf("Ошибка в файле конфигурации %s: неверная маска %q: %w", path, o.Path, err)
+			}
+		}
+		if err := o.Settings.prepare(dir); err != nil {
+			return nil, fmt.Errorf("Ошибка в файле конфигурации %s, overrides[%d]: %w", path, i, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Разбираем YAML, неизвестные поля - ошибка: опечатка в имени настройки не должна молча игнорироваться
+func decodeStrict(data []byte, v any) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Путь файла конфигурации, пустой - файла нет
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Итоговые настройки: все поля заданы
+type Resolved struct {
+	Settings
+	Sources map[string]string // имя поля => откуда взято значение
+}
+
+func newResolved() Resolved {
+	r := Resolved{Sources: make(map[string]string)}
+	r.apply(DefaultSettings(), "defaults")
+	return r
+}
+
+func (r *Resolved) apply(over Settings, source string) {
+	for _, f := range over.Fields() {
+		if f.set {
+			r.Sources[f.Name] = source
+		}
+	}
+	r.Settings = r.Settings.merge(over)
+}
+
+func (r Resolved) SelectOptions() SelectOptions {
+	return SelectOptions{
+		AudioLangs:  ParseLangs(*r.AudioLangs),
+		SubsLangs:   ParseLangs(*r.SubsLangs),
+		MaxPerLang:  *r.MaxPerLang,
+		KeepForced:  *r.KeepForced,
+		KeepComment: *r.KeepCommentary,
+	}
+}
+
+func (r Resolved) OutputOptions() (OutputOptions, error) {
+	opts := OutputOptions{Dir: *r.OutputDir}
+	if *r.Layout != "" {
+		layout, err := ParseLayout(*r.Layout)
+		if err != nil {
+			return opts, err
+		}
+		opts.Layout = layout
+	}
+	return opts, nil
+}
+
+// Настройки без привязки к файлу: значения по умолчанию, общий файл и флаги
+func (c *Config) Global() Resolved {
+	r := newResolved()
+	if c.path != "" {
+		r.apply(c.Settings, c.path)
+	}
+	r.apply(c.CommandLine, "command line")
+	return r
+}
+
+// Настройки для исходника. Порядок, от слабого к сильному: значения по умолчанию, общий файл,
+// подходящие overrides по порядку, файлы DirConfigName от корня к каталогу файла, флаги
+func (c *Config) Resolve(file string) (Resolved, error) {
+	r := newResolved()
+	if c.path != "" {
+		r.apply(c.Settings, c.path)
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return r, err
+	}
+	var show string
+	if episode, err := ParseEpisodeName(abs); err == nil {
+		show = episode.Show
+	}
+	for i, o := range c.Overrides {
+		if o.matches(abs, show) {
+			r.apply(o.Settings, fmt.Sprintf("%s overrides[%d] (%s)", c.path, i, o))
+		}
+	}
+
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		settings, err := c.dirSettings(dirs[i])
+		if err != nil {
+			return r, err
+		}
+		if settings != nil {
+			r.apply(*settings, filepath.Join(dirs[i], DirConfigName))
+		}
+	}
+
+	r.apply(c.CommandLine, "command line")
+	return r, nil
+}
+
+// Каталоги результатов для файлов files: общий output_dir и те, что задают overrides
+// и .video-converter.yaml. Файлы с ошибкой в настройках пропускаются - о ней сообщит
+// обработка самого файла. Пустой output_dir (результат рядом с исходником) не входит
+func (c *Config) OutputDirs(files []string) []string {
+	seen := make(map[string]bool)
+	res := make([]string, 0)
+	add := func(dir string) {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			res = append(res, dir)
+		}
+	}
+	add(*c.Global().OutputDir)
+	for _, file := range files {
+		if r, err := c.Resolve(file); err == nil {
+			add(*r.OutputDir)
+		}
+	}
+	return res
+}
+
+// Настройки из DirConfigName в каталоге dir, читаются один раз
+func (c *Config) dirSettings(dir string) (*Settings, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if settings, ok := c.dirs[dir]; ok {
+		return settings, c.dirErrs[dir]
+	}
+
+	settings, err := loadDirSettings(dir)
+	c.dirs[dir] = settings
+	c.dirErrs[dir] = err
+	return settings, err
+}
+
+func loadDirSettings(dir string) (*Settings, error) {
+	path := filepath.Join(dir, DirConfigName)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении файла настроек %s: %w", path, err)
+	}
+
+	var settings Settings
+	if err := decodeStrict(data, &settings); err != nil {
+		return nil, fmt.Errorf("Ошибка при разборе файла настроек %s: %w", path, err)
+	}
+	if err := settings.prepare(dir); err != nil {
+		return nil, fmt.Errorf("Ошибка в файле настроек %s: %w", path, err)
+	}
+	return &settings, nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigResolve(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "video-converter.yaml")
+	writeTestFile(t, configPath, `
+audio_langs: rus,eng
+output_dir: out
+layout: library
+overrides:
+  - path: tv/Anime
+    profile: avc720-anime
+    audio_langs: jpn,rus
+  - show: doctor who
+    sub_langs: eng
+`)
+	writeTestFile(t, filepath.Join(dir, "tv", "Anime", "Frieren", DirConfigName), "audio_langs: jpn\nkeep_commentary: true\noutput_dir: ../done\n")
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxPerLang := 0
+	cfg.CommandLine = Settings{MaxPerLang: &maxPerLang}
+
+	global := cfg.Global()
+	if *global.AudioLangs != "rus,eng" || *global.OutputDir != filepath.Join(dir, "out") || *global.MaxPerLang != 0 {
+		t.Errorf("Неверные общие настройки: %+v", global.Fields())
+	}
+	if global.Sources["max_per_lang"] != "command line" || global.Sources["keep_forced"] != "defaults" {
+		t.Errorf("Неверные источники настроек: %v", global.Sources)
+	}
+
+	tests := []struct {
+		file       string
+		profile    string
+		audioLangs string
+		subsLangs  string
+		keepComm   bool
+		outputDir  string
+		source     string // откуда взят audio_langs
+	}{
+		{"tv/Yellowstone/Yellowstone S03E01.mkv", "", "rus,eng", "rus,eng", false, "out", configPath},
+		{"tv/Doctor.Who/Doctor.Who.2005.S01E01.mkv", "", "rus,eng", "eng", false, "out", configPath},
+		{"tv/Anime/Vinland Saga - 24.mkv", "avc720-anime", "jpn,rus", "rus,eng", false, "out", configPath + ` overrides[0] (path "` + filepath.Join(dir, "tv", "Anime") + `")`},
+		{"tv/Anime/Frieren/Frieren - 12.mkv", "avc720-anime", "jpn", "rus,eng", true, "tv/Anime/done", filepath.Join(dir, "tv", "Anime", "Frieren", DirConfigName)},
+	}
+	for _, test := range tests {
+		r, err := cfg.Resolve(filepath.Join(dir, filepath.FromSlash(test.file)))
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.file, err)
+			continue
+		}
+		outputDir := filepath.Join(dir, filepath.FromSlash(test.outputDir))
+		if *r.Profile != test.profile || *r.AudioLangs != test.audioLangs || *r.SubsLangs != test.subsLangs ||
+			*r.KeepCommentary != test.keepComm || *r.OutputDir != outputDir || *r.MaxPerLang != 0 {
+			t.Errorf("%s: неверные настройки %+v", test.file, r.Fields())
+		}
+		if r.Sources["audio_langs"] != test.source {
+			t.Errorf("%s: audio_langs из %q, ожидалось из %q", test.file, r.Sources["audio_langs"], test.source)
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "video-converter.yaml"))
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
+	}
+	if cfg.Path() != "" {
+		t.Errorf("Ожидался пустой путь, получено %q", cfg.Path())
+	}
+	r, err := cfg.Resolve("Show S01E01.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r.AudioLangs != *DefaultSettings().AudioLangs {
+		t.Errorf("Ожидались настройки по умолчанию, получено %+v", r.Fields())
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, data := range []string{
+		"audio_lang: rus\n",
+		"layout: \"{show}/{group}.{ext}\"\n",
+		"max_per_lang: -1\n",
+		"overrides:\n  - profile: hevc720\n",
+		"overrides:\n  - path: \"[\"\n",
+	} {
+		path := filepath.Join(t.TempDir(), "video-converter.yaml")
+		writeTestFile(t, path, data)
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%q: ожидалась ошибка", data)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Решение: перекодировать видео или только перепаковать файл
+type Decision struct {
+	Remux  bool   // true - видео копируется без перекодирования
+	Reason string // почему принято такое решение, для логов
+}
+
+func (d Decision) String() string {
+	if d.Remux {
+		return "remux: " + d.Reason
+	}
+	return "encode: " + d.Reason
+}
+
+// Какой codec_name у ffprobe получается на выходе кодека ffmpeg
+var codecProbeNames = map[string]string{
+	"libx264":    "h264",
+	"libx265":    "hevc",
+	"libsvtav1":  "av1",
+	"libvpx-vp9": "vp9",
+}
+
+// Решаем, нужно ли перекодировать видео, или исходник уже соответствует профилю
+// и достаточно скопировать видео, выбросив лишние дорожки
+func Decide(v VideoInfo, profile Profile) Decision {
+	want := codecProbeNames[profile.Codec]
+	if v.Codec != want {
+		return Decision{Reason: fmt.Sprintf("source codec %s differs from %s", codecOrUnknown(v.Codec), want)}
+	}
+	if v.Height <= 0 {
+		return Decision{Reason: "source resolution unknown"}
+	}
+	if profile.Height > 0 && v.Height > profile.Height {
+		return Decision{Reason: fmt.Sprintf("source height %dp is above target %dp", v.Height, profile.Height)}
+	}
+	if profile.PixFmt != "" && v.PixFmt != profile.PixFmt {
+		return Decision{Reason: fmt.Sprintf("source pixel format %s differs from %s", v.PixFmt, profile.PixFmt)}
+	}
+	// фильтры профиля меняют кадр, при копировании видео они бы молча пропали
+	if len(profile.Filters) > 0 {
+		return Decision{Reason: fmt.Sprintf("profile filters %s need encoding", strings.Join(profile.Filters, ","))}
+	}
+
+	limit, err := parseBitrate(profile.MaxBitrate)
+	if err == nil && limit == 0 {
+		// без явного предела сравниваем с целевым битрейтом профиля.
+		// У профиля с crf без max_bitrate предела нет: битрейт исходника не проверяется
+		limit, err = parseBitrate(profile.Bitrate)
+	}
+	if err != nil {
+		return Decision{Reason: err.Error()}
+	}
+	if limit > 0 {
+		if v.BitRate <= 0 {
+			return Decision{Reason: "source bitrate unknown"}
+		}
+		if v.BitRate > limit {
+			return Decision{Reason: fmt.Sprintf("source bitrate %dk is above limit %dk", v.BitRate/1000, limit/1000)}
+		}
+	}
+
+	return Decision{
+		Remux:  true,
+		Reason: fmt.Sprintf("source is already %s %dp", v.Codec, v.Height),
+	}
+}
+
+func codecOrUnknown(codec string) string {
+	if codec == "" {
+		return "unknown"
+	}
+	return codec
+}
+
+// "2500k" => 2500000, "4M" => 4000000, пустая строка => 0
+func parseBitrate(str string) (int64, error) {
+	input := str
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(str, "k"), strings.HasSuffix(str, "K"):
+		multiplier = 1000
+		str = str[:len(str)-1]
+	case strings.HasSuffix(str, "M"):
+		multiplier = 1000 * 1000
+		str = str[:len(str)-1]
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", input)
+	}
+	return int64(n * float64(multiplier)), nil
+}
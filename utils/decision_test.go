@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	hevc720 := DefaultProfile()
+	limited := DefaultProfile()
+	limited.MaxBitrate = "3M"
+	tenBit := DefaultProfile()
+	tenBit.PixFmt = "yuv420p10le"
+	avc := Profile{Codec: "libx264", Bitrate: "2500k", Height: 720}
+	denoise := DefaultProfile()
+	denoise.Filters = []string{"hqdn3d"}
+
+	tests := []struct {
+		name    string
+		video   VideoInfo
+		profile Profile
+		remux   bool
+	}{
+		{"hevc 720p", VideoInfo{Codec: "hevc", Height: 720, BitRate: 2000000}, hevc720, true},
+		{"hevc 576p", VideoInfo{Codec: "hevc", Height: 576}, hevc720, true},
+		{"hevc 1080p", VideoInfo{Codec: "hevc", Height: 1080}, hevc720, false},
+		{"h264 720p", VideoInfo{Codec: "h264", Height: 720}, hevc720, false},
+		{"неизвестный кодек", VideoInfo{Height: 720}, hevc720, false},
+		{"неизвестное разрешение", VideoInfo{Codec: "hevc"}, hevc720, false},
+		{"битрейт выше предела", VideoInfo{Codec: "hevc", Height: 720, BitRate: 6000000}, limited, false},
+		{"битрейт в пределе", VideoInfo{Codec: "hevc", Height: 720, BitRate: 2500000}, limited, true},
+		{"битрейт неизвестен", VideoInfo{Codec: "hevc", Height: 720}, limited, false},
+		{"другой pix_fmt", VideoInfo{Codec: "hevc", Height: 720, PixFmt: "yuv420p"}, tenBit, false},
+		{"битрейт ниже целевого", VideoInfo{Codec: "h264", Height: 720, BitRate: 2000000}, avc, true},
+		{"битрейт выше целевого", VideoInfo{Codec: "h264", Height: 720, BitRate: 5000000}, avc, false},
+		// у crf профиля без max_bitrate битрейт не проверяется, даже очень высокий
+		{"crf без max_bitrate", VideoInfo{Codec: "hevc", Height: 720, BitRate: 40000000}, hevc720, true},
+		{"crf с max_bitrate", VideoInfo{Codec: "hevc", Height: 720, BitRate: 40000000}, limited, false},
+		{"фильтры профиля", VideoInfo{Codec: "hevc", Height: 720, BitRate: 2000000}, denoise, false},
+	}
+
+	for _, test := range tests {
+		d := Decide(test.video, test.profile)
+		if d.Remux != test.remux {
+			t.Errorf("%s: ожидалось remux=%v, получено %s", test.name, test.remux, d)
+		}
+		if d.Reason == "" {
+			t.Errorf("%s: решение без причины", test.name)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	tests := map[string]int64{
+		"":      0,
+		"2500k": 2500000,
+		"4M":    4000000,
+		"1.5M":  1500000,
+		"64000": 64000,
+	}
+	for str, expected := range tests {
+		if b, err := parseBitrate(str); err != nil || b != expected {
+			t.Errorf("Для %q ожидалось %d, получено %d (%v)", str, expected, b, err)
+		}
+	}
+	for _, str := range []string{"fast", "fastk", "-2M"} {
+		if _, err := parseBitrate(str); err == nil || !strings.Contains(err.Error(), strconv.Quote(str)) {
+			t.Errorf("Для %q ожидалась ошибка с исходным значением, получено %v", str, err)
+		}
+	}
+}
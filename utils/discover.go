@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Расширения видеофайлов по умолчанию
+var DefaultExtensions = []string{".mkv", ".mp4", ".m4v", ".avi", ".mov", ".ts", ".m2ts", ".webm", ".wmv"}
+
+// Настройки поиска исходных файлов
+type DiscoverOptions struct {
+	Extensions []string // расширения с точкой или без, регистр не важен; пустой - DefaultExtensions
+	Include    []string // glob-маски; если заданы, берутся только подходящие файлы
+	Exclude    []string // glob-маски файлов и каталогов, которые пропускаются
+	MinSize    int64    // файлы меньше этого размера в байтах пропускаются
+	KeepExtras bool     // не пропускать sample и trailer
+	SkipDirs   []string // каталоги, которые не обходятся, например каталог результатов
+}
+
+var (
+	// каталог только с sample и trailer: "Sample", "Trailers"
+	extrasDirPattern = regexp.MustCompile(`(?i)^(samples?|trailers?)$`)
+	// метка в конце имени файла без расширения: "Show.S01E01.sample", "Show-trailer".
+	// Слова в названии не мешают: "Trailer.Park.Boys.S01E01"
+	extrasFilePattern = regexp.MustCompile(`(?i)(^|[-._ ])(sample|trailer)$`)
+)
+
+// Ищем исходные файлы в paths: файлы берутся как есть, каталоги обходятся рекурсивно.
+// Маски проверяются и по имени файла, и по пути относительно каталога из paths: "*.mp4", "Extras/*".
+// Результаты прошлых запусков (".720p.H265.mkv") и временные файлы исходниками не считаются,
+// скрытые каталоги (.git, .Trash) не обходятся.
+// Возвращает пути без повторов, отсортированные по алфавиту
+func Discover(paths []string, opts DiscoverOptions) ([]string, error) {
+	exts := make(map[string]bool)
+	extensions := opts.Extensions
+	if len(extensions) == 0 {
+		extensions = DefaultExtensions
+	}
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts[ext] = true
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("неверная маска %q: %w", pattern, err)
+		}
+	}
+
+	skipDirs := make(map[string]bool)
+	for _, dir := range opts.SkipDirs {
+		if abs, err := filepath.Abs(dir); err == nil {
+			skipDirs[abs] = true
+		}
+	}
+
+	found := make(map[string]bool)
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s: %w", root, err)
+		}
+		if !info.IsDir() {
+			// файл, указанный явно, берем без фильтров
+			found[filepath.Clean(root)] = true
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			if rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+
+			if d.IsDir() {
+				if abs, err := filepath.Abs(path); err == nil && skipDirs[abs] {
+					return filepath.SkipDir
+				}
+				if strings.HasPrefix(d.Name(), ".") || matchAny(opts.Exclude, d.Name(), rel) ||
+					(!opts.KeepExtras && extrasDirPattern.MatchString(d.Name())) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !exts[strings.ToLower(filepath.Ext(d.Name()))] {
+				return nil
+			}
+			if IsOutputName(d.Name()) || matchAny(opts.Exclude, d.Name(), rel) {
+				return nil
+			}
+			if len(opts.Include) > 0 && !matchAny(opts.Include, d.Name(), rel) {
+				return nil
+			}
+			if !opts.KeepExtras && extrasFilePattern.MatchString(strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))) {
+				return nil
+			}
+			if opts.MinSize > 0 {
+				info, err := d.Info()
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						return nil
+					}
+					return err
+				}
+				if info.Size() < opts.MinSize {
+					return nil
+				}
+			}
+			found[path] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error reading directory %s: %w", root, err)
+		}
+	}
+
+	res := make([]string, 0, len(found))
+	for path := range found {
+		res = append(res, path)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Подходит ли имя или относительный путь хотя бы под одну маску
+func matchAny(patterns []string, name string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// "700M" => 734003200, "1.5G", "500k", "1024"; пустая строка => 0
+func ParseSize(str string) (int64, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToUpper(str[len(str)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		str = str[:len(str)-1]
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// Расширение выходного файла: mp4 и mov остаются как есть, потому что их субтитры
+// (mov_text) в matroska не копируются, все остальное пишем в mkv
+func OutputExt(inputFile string) string {
+	switch ext := strings.ToLower(filepath.Ext(inputFile)); ext {
+	case ".mp4", ".m4v", ".mov":
+		return ext
+	default:
+		return ".mkv"
+	}
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{
+		"Show S01E01 WEB-DL.mkv":             10,
+		"Show S01E01.720p.H265.mkv":          10,
+		"Show S01E02.mkv.partial":            10,
+		"notes.txt":                          10,
+		"Season 02/Show S02E01.MKV":          10,
+		"Season 02/Show S02E02.mp4":          10,
+		"Season 02/Show.S02E02.sample.mkv":   10,
+		"Season 02/Extras/Behind.avi":        10,
+		"Season 02/Trailers/teaser.mkv":      10,
+		"Season 02/tiny.mkv":                 1,
+		".hidden/Show S01E03.mkv":            10,
+		"Specials/Show S00E01 Christmas.mkv": 10,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Ошибка при создании временного файла: %s", err)
+		}
+	}
+	join := func(names ...string) []string {
+		res := make([]string, 0, len(names))
+		for _, name := range names {
+			res = append(res, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		return res
+	}
+
+	tests := []struct {
+		name     string
+		paths    []string
+		opts     DiscoverOptions
+		expected []string
+	}{
+		{
+			name:  "по умолчанию",
+			paths: []string{dir},
+			expected: join(
+				"Season 02/Extras/Behind.avi", "Season 02/Show S02E01.MKV", "Season 02/Show S02E02.mp4",
+				"Season 02/tiny.mkv", "Show S01E01 WEB-DL.mkv", "Specials/Show S00E01 Christmas.mkv",
+			),
+		},
+		{
+			name:     "только mkv, без маленьких и каталога Extras",
+			paths:    []string{dir},
+			opts:     DiscoverOptions{Extensions: []string{"MKV"}, Exclude: []string{"Extras", "Specials/*"}, MinSize: 5},
+			expected: join("Season 02/Show S02E01.MKV", "Show S01E01 WEB-DL.mkv"),
+		},
+		{
+			name:     "include по относительному пути",
+			paths:    []string{dir},
+			opts:     DiscoverOptions{Include: []string{"Season 02/Show*"}},
+			expected: join("Season 02/Show S02E01.MKV", "Season 02/Show S02E02.mp4"),
+		},
+		{
+			name:     "sample и trailer по запросу",
+			paths:    []string{filepath.Join(dir, "Season 02")},
+			opts:     DiscoverOptions{Extensions: []string{".mkv"}, KeepExtras: true},
+			expected: join("Season 02/Show S02E01.MKV", "Season 02/Show.S02E02.sample.mkv", "Season 02/Trailers/teaser.mkv", "Season 02/tiny.mkv"),
+		},
+		{
+			name:     "без каталога результатов",
+			paths:    []string{dir},
+			opts:     DiscoverOptions{SkipDirs: join("Season 02")},
+			expected: join("Show S01E01 WEB-DL.mkv", "Specials/Show S00E01 Christmas.mkv"),
+		},
+		{
+			name:     "файл и каталог без повторов",
+			paths:    join("Specials/Show S00E01 Christmas.mkv", "Specials"),
+			expected: join("Specials/Show S00E01 Christmas.mkv"),
+		},
+	}
+	for _, test := range tests {
+		actual, err := Discover(test.paths, test.opts)
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: ожидалось %v, получено %v", test.name, test.expected, actual)
+		}
+	}
+
+	if _, err := Discover([]string{filepath.Join(dir, "missing")}, DiscoverOptions{}); err == nil {
+		t.Error("Ожидалась ошибка для несуществующего пути")
+	}
+	if _, err := Discover([]string{dir}, DiscoverOptions{Exclude: []string{"[a-"}}); err == nil {
+		t.Error("Ожидалась ошибка для неверной маски")
+	}
+}
+
+// sample и trailer в названии сериала - не повод пропускать серии
+func TestDiscoverExtrasInTitle(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Trailer Park Boys/Season 01/Trailer.Park.Boys.S01E01.mkv",
+		"Trailer Park Boys/Season 01/Trailer.Park.Boys.S01E01.sample.mkv",
+		"Trailer Park Boys/Season 01/Sample/Trailer.Park.Boys.S01E02.mkv",
+		"The Sampler/The Sampler S01E01.mkv",
+		"The Sampler/The Sampler S01E01-trailer.mkv",
+		"Sample Size/Sample.Size.S01E01.mkv",
+		"Trailers/teaser.mkv",
+	} {
+		writeTestFile(t, filepath.Join(dir, filepath.FromSlash(name)), "video")
+	}
+
+	actual, err := Discover([]string{dir}, DiscoverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "Sample Size", "Sample.Size.S01E01.mkv"),
+		filepath.Join(dir, "The Sampler", "The Sampler S01E01.mkv"),
+		filepath.Join(dir, "Trailer Park Boys", "Season 01", "Trailer.Park.Boys.S01E01.mkv"),
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, actual)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"": 0, "1024": 1024, "500k": 500 << 10, "700M": 700 << 20, "1.5G": 3 << 29}
+	for str, expected := range tests {
+		if actual, err := ParseSize(str); err != nil || actual != expected {
+			t.Errorf("%q: ожидалось %d, получено %d (%v)", str, expected, actual, err)
+		}
+	}
+	if _, err := ParseSize("big"); err == nil {
+		t.Error("Ожидалась ошибка для неверного размера")
+	}
+}
+
+func TestOutputExt(t *testing.T) {
+	tests := map[string]string{"a.mkv": ".mkv", "a.AVI": ".mkv", "a.MP4": ".mp4", "a.mov": ".mov", "a.ts": ".mkv"}
+	for input, expected := range tests {
+		if actual := OutputExt(input); actual != expected {
+			t.Errorf("%s: ожидалось %s, получено %s", input, expected, actual)
+		}
+	}
+}
+
+// Результаты по шаблону не отличить по имени, их каталоги берутся из настроек:
+// общий output_dir, overrides и .video-converter.yaml
+func TestDiscoverLayoutOutputs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "video-converter.yaml"), `
+output_dir: converted
+layout: library
+overrides:
+  - show: frieren
+    output_dir: anime
+`)
+	writeTestFile(t, filepath.Join(dir, "tv", "Doctor Who", DirConfigName), "output_dir: done\n")
+	for _, name := range []string{
+		"tv/Show/Show S01E01 Pilot.mkv",
+		"converted/Show/Season 01/Show - S01E01 - Pilot.mkv",
+		"tv/Frieren/Frieren - S01E12.mkv",
+		"anime/Frieren/Season 01/Frieren - S01E12.mkv",
+		"tv/Doctor Who/Doctor Who S01E01.mkv",
+		"tv/Doctor Who/done/Doctor Who/Season 01/Doctor Who - S01E01.mkv",
+	} {
+		writeTestFile(t, filepath.Join(dir, filepath.FromSlash(name)), "video")
+	}
+	if IsOutputName("Show - S01E01 - Pilot.mkv") {
+		t.Fatal("Имя по шаблону не должно узнаваться как результат")
+	}
+
+	cfg, err := LoadConfig(filepath.Join(dir, "video-converter.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Discover([]string{dir}, DiscoverOptions{SkipDirs: []string{*cfg.Global().OutputDir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := Discover([]string{dir}, DiscoverOptions{SkipDirs: cfg.OutputDirs(files)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "tv", "Doctor Who", "Doctor Who S01E01.mkv"),
+		filepath.Join(dir, "tv", "Frieren", "Frieren - S01E12.mkv"),
+		filepath.Join(dir, "tv", "Show", "Show S01E01 Pilot.mkv"),
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, actual)
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Как часто выводить строку прогресса одного файла, если вывод не терминал
+const plainProgressInterval = 30 * time.Second
+
+// Вывод прогресса всех одновременно идущих кодирований.
+// В терминале - по строке на файл, которые перерисовываются на месте,
+// иначе (перенаправление в файл, cron) - обычные строки не чаще plainProgressInterval.
+// Display реализует io.Writer, чтобы обычные сообщения не ломали блок прогресса
+type Display struct {
+	mu    sync.Mutex
+	out   io.Writer
+	tty   bool
+	jobs  map[string]Progress
+	order []string
+	lines int // сколько строк прогресса сейчас нарисовано
+	last  map[string]time.Time
+}
+
+func NewDisplay(out io.Writer, tty bool) *Display {
+	return &Display{
+		out:  out,
+		tty:  tty,
+		jobs: make(map[string]Progress),
+		last: make(map[string]time.Time),
+	}
+}
+
+// Выводим в stdout, многострочный режим - только если stdout это терминал
+func NewStdoutDisplay() *Display {
+	return NewDisplay(os.Stdout, IsTerminal(os.Stdout))
+}
+
+// Проверяем, что файл - это терминал, а не канал или обычный файл
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Обновляем прогресс файла
+func (d *Display) Update(p Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.jobs[p.File]; !ok {
+		d.order = append(d.order, p.File)
+	}
+	d.jobs[p.File] = p
+
+	if d.tty {
+		d.clear()
+		d.draw()
+		return
+	}
+
+	if p.Done || time.Since(d.last[p.File]) >= plainProgressInterval {
+		d.last[p.File] = time.Now()
+		fmt.Fprintln(d.out, p.String())
+	}
+}
+
+// Убираем файл из блока прогресса
+func (d *Display) Remove(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.jobs[file]; !ok {
+		return
+	}
+	if d.tty {
+		d.clear()
+	}
+	delete(d.jobs, file)
+	delete(d.last, file)
+	for i, f := range d.order {
+		if f == file {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+	if d.tty {
+		d.draw()
+	}
+}
+
+// Обычные сообщения выводятся над блоком прогресса
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tty {
+		d.clear()
+	}
+	n, err := d.out.Write(p)
+	if d.tty {
+		d.draw()
+	}
+	return n, err
+}
+
+// Стираем нарисованные строки прогресса: поднимаемся вверх и чистим до конца экрана
+func (d *Display) clear() {
+	if d.lines > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.lines)
+		d.lines = 0
+	}
+}
+
+func (d *Display) draw() {
+	for _, file := range d.order {
+		fmt.Fprintf(d.out, "\x1b[2K%s\n", d.jobs[file])
+	}
+	d.lines = len(d.order)
+}
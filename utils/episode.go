@@ -0,0 +1,527 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Что удалось узнать о серии из имени файла и каталогов
+type EpisodeName struct {
+	Show       string
+	Season     int    // -1 - неизвестен
+	Episode    int    // -1 - неизвестен
+	EpisodeEnd int    // последняя серия в файле из нескольких серий, -1 - серия одна
+	Absolute   int    // сквозной номер серии (аниме), -1 - неизвестен
+	Date       string // дата выпуска ежедневного шоу: "2023-05-14"
+	Title      string
+	Year       int    // 0 - неизвестен
+	Resolution string // разрешение исходника из имени: "1080p", "2160p"
+	Tags       []string
+}
+
+func newEpisodeName() EpisodeName {
+	return EpisodeName{Season: -1, Episode: -1, EpisodeEnd: -1, Absolute: -1, Tags: make([]string, 0)}
+}
+
+// Строка для логов и тестов: show="Yellowstone" year=2018 s=3 e=1 res=2160p tags=[WEB-DL]
+func (n EpisodeName) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "show=%q", n.Show)
+	if n.Year > 0 {
+		fmt.Fprintf(&b, " year=%d", n.Year)
+	}
+	if n.Season >= 0 {
+		fmt.Fprintf(&b, " s=%d", n.Season)
+	}
+	if n.Episode >= 0 {
+		fmt.Fprintf(&b, " e=%d", n.Episode)
+	}
+	if n.EpisodeEnd >= 0 {
+		fmt.Fprintf(&b, "-%d", n.EpisodeEnd)
+	}
+	if n.Absolute >= 0 {
+		fmt.Fprintf(&b, " abs=%d", n.Absolute)
+	}
+	if n.Date != "" {
+		fmt.Fprintf(&b, " date=%s", n.Date)
+	}
+	if n.Title != "" {
+		fmt.Fprintf(&b, " title=%q", n.Title)
+	}
+	if n.Resolution != "" {
+		fmt.Fprintf(&b, " res=%s", n.Resolution)
+	}
+	if len(n.Tags) > 0 {
+		fmt.Fprintf(&b, " tags=[%s]", strings.Join(n.Tags, " "))
+	}
+	return b.String()
+}
+
+var (
+	// Show S03E01, Show.s01e02-03, Show S01E01-E02, Show S01E01E02, Show S01E01-S01E02, Show.S01.E01
+	seasonEpisodePattern = regexp.MustCompile(`(?i)(?:^|[\s._\-\[(])s(\d{1,2})[\s._-]?e(\d{1,4})((?:-?e\d{1,4}|-s\d{1,2}e\d{1,4}|-\d{1,4})*)(?:$|[\s._\-\])])`)
+	// 01x00 Pilot, Show 1x05, Show 1x01-1x02, Show 1x01-02
+	crossPattern = regexp.MustCompile(`(?:^|[\s._\-\[(])(\d{1,2})x(\d{2,3})(?:(?:-(?:\d{1,2}x)?|x)(\d{2,3}))?(?:$|[\s._\-\])])`)
+	// Show.2023.05.14, Show 2023-05-14
+	datePattern = regexp.MustCompile(`(?:^|[\s._\-\[(])((?:19|20)\d\d)[.\-_ ](\d\d)[.\-_ ](\d\d)(?:$|[\s._\-\])])`)
+	// сквозной номер аниме: "Show - 123 [1080p]", "Show - 05v2"
+	absolutePattern = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:$|[\s\[(])`)
+	// сезон перед номером аниме: "Show S2 - 24" - номер серии внутри сезона
+	animeSeasonPattern = regexp.MustCompile(`(?i)\s(?:s|season\s?)(\d{1,2})$`)
+	// 01. The One Where Monica Gets a Roommate, 01 - Pilot
+	numberPattern = regexp.MustCompile(`^(\d{1,3})(?:\.\s*|\s+-\s+)`)
+
+	// Season 02, S02, Сезон 2 в имени каталога
+	seasonDirPattern = regexp.MustCompile(`(?i)^(?:season|сезон|s)\s*(\d{1,2})$`)
+	// [Group] в начале имени
+	leadingGroupPattern = regexp.MustCompile(`^\s*\[([^\]]*)\][\s._-]*`)
+	// [CBS Drama+OPT+Eng], (2019)
+	bracketPattern = regexp.MustCompile(`\[([^\]]*)\]|\(([^)]*)\)`)
+	yearPattern    = regexp.MustCompile(`^(19|20)\d\d$`)
+	// номер части серии в скобках после названия: "Pilot (1)", "Daybreak (3)"
+	partPattern = regexp.MustCompile(`^\(\d{1,2}\)$`)
+	// 1080p, 720i, 4K, 1920x1080, BD1080p
+	resolutionPattern = regexp.MustCompile(`(?i)^(?:bd)?(\d{3,4})[pi]$|^(4k|uhd)$|^\d{3,4}x(\d{3,4})$`)
+
+	// Метки релиза, с которых начинается техническая часть имени
+	releaseTagPattern = regexp.MustCompile(`(?i)^(web-?dl|web-?rip|web|hdtv(rip)?|hdrip|blu-?ray|bd-?rip|br-?rip|bd-?remux|remux|dvd-?rip|dvd[59]?|sat-?rip|tv-?rip|x26[45]|h\.?26[45]|hevc|avc|av1|xvid|divx|10-?bit|8-?bit|hdr(10(\+|plus)?)?|sdr|dovi|dolby-?vision|ddp?\d\.\d|dd\+?\d\.\d|e-?ac-?3|ac-?3|aac(\d\.\d)?|dts(-hd)?(-ma)?|truehd|atmos|flac|opus)$`)
+	// Метки, которые могут быть и обычными словами в названии: считаем их метками, только если они заглавные.
+	// Сюда же язык озвучки: "GERMAN.DL", "FRENCH"
+	upperTagPattern = regexp.MustCompile(`^(AMZN|NF|DSNP|HMAX|ATVP|HULU|PCOK|PMTP|iT|DV|PROPER|REPACK|RERIP|INTERNAL|REAL|MULTI|DUAL|EXTENDED|UNCUT|RUS|ENG|SUB|SUBS|` +
+		`GERMAN|DL|FRENCH|SPANISH|ITALIAN|DANISH|SWEDISH|NORWEGIAN|FINNISH|NORDiC|DUTCH|POLISH|KOREAN|JAPANESE)$`)
+)
+
+// Разбираем имя файла серии: название сериала, сезон, серия, название серии, год,
+// разрешение и метки релиза. Если в имени нет названия сериала или номера сезона,
+// берем их из каталогов: "Show/Season 02/01. Title.mkv"
+func ParseEpisodeName(path string) (EpisodeName, error) {
+	base := filepath.Base(path)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	res := newEpisodeName()
+
+	// имена с пробелами: точки - часть названия ("Mr. Robot"), без пробелов - разделители
+	dotted := !strings.Contains(strings.TrimSpace(stem), " ")
+
+	if m := leadingGroupPattern.FindStringSubmatchIndex(stem); m != nil {
+		if group := strings.TrimSpace(stem[m[2]:m[3]]); group != "" {
+			res.Tags = append(res.Tags, group)
+		}
+		stem = stem[m[1]:]
+	}
+
+	var head, tail string
+	if m := seasonEpisodePattern.FindStringSubmatchIndex(stem); m != nil {
+		res.Season, _ = strconv.Atoi(stem[m[2]:m[3]])
+		res.Episode, _ = strconv.Atoi(stem[m[4]:m[5]])
+		// несколько серий в файле: "E01E02E03", "E01-02" - запоминаем последнюю
+		if more := digitsPattern.FindAllString(stem[m[6]:m[7]], -1); len(more) > 0 {
+			res.EpisodeEnd, _ = strconv.Atoi(more[len(more)-1])
+		}
+		head, tail = stem[:m[0]], stem[m[1]:]
+	} else if m := crossPattern.FindStringSubmatchIndex(stem); m != nil {
+		res.Season, _ = strconv.Atoi(stem[m[2]:m[3]])
+		res.Episode, _ = strconv.Atoi(stem[m[4]:m[5]])
+		if m[6] >= 0 {
+			res.EpisodeEnd, _ = strconv.Atoi(stem[m[6]:m[7]])
+		}
+		head, tail = stem[:m[0]], stem[m[1]:]
+	} else if m, date := findDate(stem); m != nil {
+		res.Date = date
+		head, tail = stem[:m[0]], stem[m[1]:]
+	} else if m := findAbsolute(stem); m != nil {
+		res.Absolute, _ = strconv.Atoi(stem[m[2]:m[3]])
+		head, tail = stem[:m[0]], stem[m[1]:]
+		if s := animeSeasonPattern.FindStringSubmatchIndex(head); s != nil {
+			res.Season, _ = strconv.Atoi(head[s[2]:s[3]])
+			res.Episode, res.Absolute = res.Absolute, -1
+			head = head[:s[0]]
+		}
+	} else if m := numberPattern.FindStringSubmatchIndex(stem); m != nil {
+		res.Episode, _ = strconv.Atoi(stem[m[2]:m[3]])
+		tail = stem[m[1]:]
+	} else {
+		return res, &NamePatternError{File: base}
+	}
+	// маркер серии мог захватить открывающую скобку: "Show (S01E01)"
+	if strings.HasSuffix(head, "[") || strings.HasSuffix(head, "(") {
+		head = head[:len(head)-1]
+	}
+
+	res.parseHead(head, dotted)
+	res.parseTail(tail, dotted)
+	res.fromDirs(path)
+	return res, nil
+}
+
+var digitsPattern = regexp.MustCompile(`\d+`)
+
+// Первая настоящая дата в имени: "2023.05.14" => "2023-05-14", "2023.13.45" датой не считается
+func findDate(stem string) ([]int, string) {
+	for _, m := range datePattern.FindAllStringSubmatchIndex(stem, -1) {
+		date := stem[m[2]:m[3]] + "-" + stem[m[4]:m[5]] + "-" + stem[m[6]:m[7]]
+		if _, err := time.Parse("2006-01-02", date); err == nil {
+			return m, date
+		}
+	}
+	return nil, ""
+}
+
+// Сквозной номер серии после " - ". Год номером не считается: "Show - 2019 - Title"
+func findAbsolute(stem string) []int {
+	for _, m := range absolutePattern.FindAllStringSubmatchIndex(stem, -1) {
+		if !yearPattern.MatchString(stem[m[2]:m[3]]) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Короткое имя серии для выходного файла: "Show S01E01", "Show S01E01-E02",
+// "Show 2023-05-14", "Show - 123"
+func (n EpisodeName) ShortName() string {
+	var code string
+	switch {
+	case n.Season >= 0 && n.Episode >= 0:
+		code = fmt.Sprintf("S%02dE%02d", n.Season, n.Episode)
+		if n.EpisodeEnd >= 0 {
+			code += fmt.Sprintf("-E%02d", n.EpisodeEnd)
+		}
+	case n.Date != "":
+		code = n.Date
+	case n.Absolute >= 0 && n.Show != "":
+		code = fmt.Sprintf("- %02d", n.Absolute)
+	case n.Absolute >= 0:
+		code = fmt.Sprintf("%02d", n.Absolute)
+	case n.Episode >= 0:
+		code = fmt.Sprintf("E%02d", n.Episode)
+	}
+	return strings.TrimSpace(n.Show + " " + code)
+}
+
+// Часть имени до номера серии: название сериала и год
+func (n *EpisodeName) parseHead(head string, dotted bool) {
+	// год и прочее в скобках: "Show (2019) [Group]"
+	region := ""
+	head = bracketPattern.ReplaceAllStringFunc(head, func(bracket string) string {
+		content := strings.TrimSpace(bracket[1 : len(bracket)-1])
+		if yearPattern.MatchString(content) {
+			n.Year, _ = strconv.Atoi(content)
+		} else if regionPattern.MatchString(content) {
+			region = content
+		} else if content != "" {
+			n.Tags = append(n.Tags, content)
+		}
+		return " "
+	})
+
+	words := splitWords(head, dotted)
+	// тире перед номером серии: "Show 2005 - S01E01"
+	for len(words) > 0 && isDash(words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	// год в конце названия без скобок: "Doctor.Who.2005.S01E01"
+	if len(words) > 1 && yearPattern.MatchString(words[len(words)-1]) && n.Year == 0 {
+		n.Year, _ = strconv.Atoi(words[len(words)-1])
+		words = words[:len(words)-1]
+	}
+	// страна версии сериала без скобок: "The.Office.US.S01E01", но не "The.Last.of.Us"
+	if len(words) > 1 && region == "" && isRegion(words[len(words)-1], words[len(words)-2]) {
+		region = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	n.Show = normalizeShow(words, region)
+}
+
+var (
+	// страна версии сериала: "The Office (US)", "Life on Mars UK"
+	regionPattern = regexp.MustCompile(`(?i)^(us|uk|au|nz|ca)$`)
+	// служебные слова, которые в названии пишутся с маленькой буквы
+	smallWords = map[string]bool{
+		"a": true, "an": true, "and": true, "as": true, "at": true, "but": true, "by": true, "for": true,
+		"in": true, "of": true, "on": true, "or": true, "the": true, "to": true, "with": true,
+	}
+)
+
+// Последнее слово названия - страна, если написано заглавными ("US") или все имя
+// в нижнем регистре ("the.office.us") и перед ним не служебное слово ("of us")
+func isRegion(word, prev string) bool {
+	if !regionPattern.MatchString(word) {
+		return false
+	}
+	return word == strings.ToUpper(word) || word == strings.ToLower(word) && !smallWords[strings.ToLower(prev)]
+}
+
+// Одно название для всех вариантов записи: "Mr. Robot" и "Mr.Robot" => "Mr Robot",
+// "game.of.thrones" => "Game of Thrones", "The.Office.US" и "The Office (US)" => "The Office (US)"
+func normalizeShow(words []string, region string) string {
+	lower := true
+	for i, word := range words {
+		// точка сокращения: "Mr." => "Mr", но "S.H.I.E.L.D." остается как есть
+		if trimmed := strings.TrimSuffix(word, "."); trimmed != "" && !strings.Contains(trimmed, ".") {
+			words[i] = trimmed
+		}
+		if strings.ToLower(word) != word {
+			lower = false
+		}
+	}
+	// название целиком в нижнем регистре - обычно так пишут только в именах релизов
+	if lower {
+		for i, word := range words {
+			if i == 0 || i == len(words)-1 || !smallWords[word] {
+				words[i] = titleWord(word)
+			}
+		}
+	}
+
+	show := joinWords(words)
+	if region != "" && show != "" {
+		show += " (" + strings.ToUpper(region) + ")"
+	}
+	return show
+}
+
+// Первая буква заглавная, и после дефиса тоже: "thrones" => "Thrones", "nine-nine" => "Nine-Nine"
+func titleWord(word string) string {
+	runes := []rune(word)
+	for i := range runes {
+		if i == 0 || runes[i-1] == '-' {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+// Часть имени после номера серии: название серии, затем метки релиза
+func (n *EpisodeName) parseTail(tail string, dotted bool) {
+	titlePart, bracketPart := tail, ""
+	if loc := bracketPattern.FindStringIndex(tail); loc != nil {
+		end := loc[0]
+		if partPattern.MatchString(tail[loc[0]:loc[1]]) {
+			end = loc[1]
+		}
+		titlePart, bracketPart = tail[:end], tail[end:]
+	}
+
+	words := splitWords(titlePart, dotted)
+	title := words
+	for i, word := range words {
+		if isReleaseTag(word) {
+			title = words[:i]
+			n.addTags(words[i:])
+			break
+		}
+	}
+	n.Title = joinWords(title)
+
+	// метки в скобках и между ними: "[1080p] [Rus+Eng] WEB-DL"
+	rest := bracketPattern.ReplaceAllStringFunc(bracketPart, func(bracket string) string {
+		content := strings.TrimSpace(bracket[1 : len(bracket)-1])
+		if yearPattern.MatchString(content) && n.Year == 0 {
+			n.Year, _ = strconv.Atoi(content)
+		} else {
+			n.addBracketTags(content)
+		}
+		return " "
+	})
+	n.addTags(splitWords(rest, dotted))
+}
+
+// Содержимое скобок: набор меток "[WEBDL-1080p x265]" делим на слова,
+// все остальное ("[CBS Drama+OPT+Eng]") оставляем одной меткой
+func (n *EpisodeName) addBracketTags(content string) {
+	if content == "" {
+		return
+	}
+	words := splitWords(content, false)
+	for _, word := range words {
+		if isReleaseTag(word) {
+			n.addTags(words)
+			return
+		}
+	}
+	n.Tags = append(n.Tags, content)
+}
+
+// Слово - метка релиза или разрешение: с него начинается техническая часть имени.
+// Сюда же относятся "x265-GROUP" и "WEBDL-1080p"
+func isReleaseTag(word string) bool {
+	if resolutionPattern.MatchString(word) || releaseTagPattern.MatchString(word) || upperTagPattern.MatchString(word) {
+		return true
+	}
+	prefix := groupPrefix(word)
+	return prefix != "" && (releaseTagPattern.MatchString(prefix) || resolutionPattern.MatchString(word[len(prefix)+1:]))
+}
+
+// Добавляем слова из технической части имени: разрешение отдельно, группу после "-" отдельно
+func (n *EpisodeName) addTags(words []string) {
+	for _, word := range joinAudioTags(words) {
+		if resolutionPattern.MatchString(word) {
+			if n.Resolution == "" {
+				n.Resolution = normalizeResolution(word)
+			}
+			continue
+		}
+		if isDash(word) {
+			continue
+		}
+		// x265-GROUP => x265, GROUP; WEBDL-1080p => WEBDL, 1080p; WEB-DL остается как есть
+		prefix := groupPrefix(word)
+		if prefix != "" && !releaseTagPattern.MatchString(word) && !isAudioTag(word) {
+			suffix := word[len(prefix)+1:]
+			if resolutionPattern.MatchString(suffix) {
+				n.addTags([]string{prefix, suffix})
+				continue
+			}
+			if releaseTagPattern.MatchString(prefix) || upperTagPattern.MatchString(prefix) || resolutionPattern.MatchString(prefix) {
+				n.addTags([]string{prefix})
+				word = suffix
+			}
+		}
+		n.Tags = append(n.Tags, word)
+	}
+}
+
+var (
+	// Аудиокодек, после которого идут профиль и каналы: "DTS-HD MA 5.1", "TrueHD.7.1.Atmos"
+	audioCodecPattern = regexp.MustCompile(`(?i)^(dts(-hd|-x|-es)?|truehd|ddp?|dd\+|e-?ac-?3|ac-?3|aac|flac|opus|l?pcm)(\d\.\d)?$`)
+	audioPartPattern  = regexp.MustCompile(`(?i)^(ma|hra|\d\.\d|atmos|ma\.\d\.\d)$`)
+)
+
+// Склеиваем описание звука, разрезанное разделителями, в одну метку:
+// "DTS-HD", "MA", "5.1" => "DTS-HD.MA.5.1". Группа после "-" остается отдельным словом
+func joinAudioTags(words []string) []string {
+	res := make([]string, 0, len(words))
+	audio := false
+	for _, word := range words {
+		if audio && len(res) > 0 {
+			if audioPartPattern.MatchString(word) {
+				res[len(res)-1] += "." + word
+				continue
+			}
+			// "DTS-HD.MA.5.1-GROUP" => "DTS-HD.MA.5.1", "GROUP"
+			if prefix := groupPrefix(word); prefix != "" && audioPartPattern.MatchString(prefix) {
+				res[len(res)-1] += "." + prefix
+				res = append(res, word[len(prefix)+1:])
+				audio = false
+				continue
+			}
+		}
+		audio = audioCodecPattern.MatchString(word)
+		res = append(res, word)
+	}
+	return res
+}
+
+// Склеенное описание звука: "DTS-HD.MA.5.1", "TrueHD.Atmos"
+func isAudioTag(word string) bool {
+	codec, _, _ := strings.Cut(word, ".")
+	return audioCodecPattern.MatchString(codec) || audioCodecPattern.MatchString(word)
+}
+
+// Часть слова до последнего "-": "x265-GROUP" => "x265", без "-" - пустая строка
+func groupPrefix(word string) string {
+	i := strings.LastIndex(word, "-")
+	if i <= 0 || i == len(word)-1 {
+		return ""
+	}
+	return word[:i]
+}
+
+// 1080P => 1080p, 4K => 2160p, 1920x1080 => 1080p
+func normalizeResolution(str string) string {
+	m := resolutionPattern.FindStringSubmatch(str)
+	if m == nil {
+		return ""
+	}
+	if m[2] != "" {
+		return "2160p"
+	}
+	if m[3] != "" {
+		return m[3] + "p"
+	}
+	return m[1] + strings.ToLower(str[len(str)-1:])
+}
+
+// Чего нет в имени файла, ищем в каталогах: сезон в "Season 02", название сериала - выше
+func (n *EpisodeName) fromDirs(path string) {
+	dir := filepath.Base(filepath.Dir(path))
+	if m := seasonDirPattern.FindStringSubmatch(dir); m != nil {
+		if n.Season < 0 {
+			n.Season, _ = strconv.Atoi(m[1])
+		}
+		dir = filepath.Base(filepath.Dir(filepath.Dir(path)))
+	}
+	if n.Show == "" && dir != "." && dir != string(filepath.Separator) {
+		show := newEpisodeName()
+		show.parseHead(dir, !strings.Contains(dir, " "))
+		n.Show = show.Show
+		if n.Year == 0 {
+			n.Year = show.Year
+		}
+	}
+}
+
+// Делим часть имени на слова. Точка - разделитель только в именах без пробелов
+// и никогда не внутри меток: "DDP5.1", "H.265". Отдельно стоящее тире в именах
+// с пробелами остается словом: "Star Trek - The Next Generation"
+func splitWords(str string, dotted bool) []string {
+	words := make([]string, 0)
+	var word strings.Builder
+	flush := func() {
+		if w := strings.Trim(word.String(), "-–"); w != "" {
+			words = append(words, w)
+		} else if !dotted && isDash(word.String()) {
+			words = append(words, word.String())
+		}
+		word.Reset()
+	}
+
+	runes := []rune(str)
+	for i, r := range runes {
+		switch {
+		case r == ' ' || r == '_':
+			flush()
+		case r == '.' && dotted:
+			// "DDP5.1", "H.265", но "1.23.45.1080p" => "1.23.45", "1080p"
+			next := i < len(runes)-1 && isDigit(runes[i+1]) && !resolutionPattern.MatchString(nextWord(runes[i+1:]))
+			if next && i > 0 && (isDigit(runes[i-1]) || strings.EqualFold(word.String(), "h")) {
+				word.WriteRune(r)
+			} else {
+				flush()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// Слово до следующего разделителя
+func nextWord(runes []rune) string {
+	for i, r := range runes {
+		if r == '.' || r == ' ' || r == '_' {
+			return string(runes[:i])
+		}
+	}
+	return string(runes)
+}
+
+// Тире между частями названия: "-", "–"
+func isDash(word string) bool {
+	return word == "-" || word == "–"
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// Собираем слова обратно, без висящих разделителей по краям
+func joinWords(words []string) string {
+	return strings.Trim(strings.Join(words, " "), " -–.,")
+}
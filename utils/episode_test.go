@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEpisodeName(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected EpisodeName
+	}{
+		{
+			"Yellowstone S03E01 WEB-DL 2160p.mkv",
+			EpisodeName{Show: "Yellowstone", Season: 3, Episode: 1, EpisodeEnd: -1, Absolute: -1, Resolution: "2160p", Tags: []string{"WEB-DL"}},
+		},
+		{
+			"Doctor.Who.2005.S01E01.Rose.720p.BluRay.x264-SHORTBREHD.mkv",
+			EpisodeName{
+				Show: "Doctor Who", Year: 2005, Season: 1, Episode: 1, EpisodeEnd: -1, Absolute: -1, Title: "Rose",
+				Resolution: "720p", Tags: []string{"BluRay", "x264", "SHORTBREHD"},
+			},
+		},
+		{
+			"Friends/Season 01/01. The One Where Monica Gets a Roommate.mkv",
+			EpisodeName{Show: "Friends", Season: 1, Episode: 1, EpisodeEnd: -1, Absolute: -1, Title: "The One Where Monica Gets a Roommate", Tags: []string{}},
+		},
+	}
+	for _, test := range tests {
+		actual, err := ParseEpisodeName(filepath.FromSlash(test.path))
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: ожидалось %+v, получено %+v", test.path, test.expected, actual)
+		}
+	}
+
+	var patternErr *NamePatternError
+	if _, err := ParseEpisodeName("Movie 1920x1080.mkv"); !errors.As(err, &patternErr) {
+		t.Errorf("Ожидалась NamePatternError, получено %v", err)
+	}
+}
+
+// Имена настоящих релизов из testdata/names/episodes.txt, в каждой строке через табуляцию
+// имя и ожидаемый результат разбора или "error". Ожидаемые значения проверены вручную,
+// файл не перезаписывается из вывода разбора
+func TestParseEpisodeNameCorpus(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "names", "episodes.txt"))
+	if err != nil {
+		t.Fatalf("Ошибка при чтении списка имен: %v", err)
+	}
+
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		name, expected, ok := strings.Cut(line, "\t")
+		if !ok {
+			t.Fatalf("episodes.txt, строка %d: ожидались имя и результат через табуляцию", i+1)
+		}
+		parsed, err := ParseEpisodeName(filepath.FromSlash(name))
+		actual := "error"
+		if err == nil {
+			actual = parsed.String()
+		}
+		if actual != expected {
+			t.Errorf("%s:\nожидалось: %s\nполучено:  %s", name, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ffmpeg или ffprobe не найдены в PATH
+var ErrToolNotFound = errors.New("tool not found")
+
+// Сколько последних строк вывода ffmpeg сохранять в ошибке
+const stderrTailLines = 20
+
+// Ошибка ffprobe при получении информации о файле
+type ProbeError struct {
+	File   string
+	Err    error
+	Stderr string // хвост вывода ffprobe
+}
+
+func (e *ProbeError) Error() string {
+	msg := fmt.Sprintf("probe failed for %s: %v", e.File, e.Err)
+	if e.Stderr != "" {
+		msg += "\n" + e.Stderr
+	}
+	return msg
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// В файле нет ни одной аудиодорожки на нужных языках
+type NoAudioError struct {
+	File string
+}
+
+func (e *NoAudioError) Error() string {
+	return fmt.Sprintf("Can't convert because no audio track in %s matches the preferred languages", e.File)
+}
+
+// В файле нет видеопотока
+type NoVideoError struct {
+	File string
+}
+
+func (e *NoVideoError) Error() string {
+	return fmt.Sprintf("Can't convert because there is no video stream in %s", e.File)
+}
+
+// Ни один паттерн имени файла не подошел
+type NamePatternError struct {
+	File string
+}
+
+func (e *NamePatternError) Error() string {
+	return fmt.Sprintf("ни один из паттернов не найден в имени файла: %s", e.File)
+}
+
+// В имени файла нет поля, нужного для шаблона пути
+type LayoutError struct {
+	File  string
+	Field string
+}
+
+func (e *LayoutError) Error() string {
+	return fmt.Sprintf("can't fill {%s} in output layout for %s", e.Field, e.File)
+}
+
+// Строка истории, которую не удалось разобрать: такие строки пропускаются
+type HistoryLineError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *HistoryLineError) Error() string {
+	return fmt.Sprintf("history %s, line %d skipped: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *HistoryLineError) Unwrap() error {
+	return e.Err
+}
+
+// Ошибка кодирования ffmpeg
+type EncodeError struct {
+	File   string
+	Err    error
+	Stderr string // последние строки вывода ffmpeg
+}
+
+func (e *EncodeError) Error() string {
+	msg := fmt.Sprintf("encoding %s failed: %v", e.File, e.Err)
+	if e.Stderr != "" {
+		msg += "\n" + e.Stderr
+	}
+	return msg
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// Готовый файл не прошел проверку после кодирования
+type VerifyError struct {
+	File   string
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verification of %s failed: %s", e.File, e.Reason)
+}
+
+// Последние n строк вывода без пустых строк в конце
+func tailLines(out []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n "), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,149 +1,195 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 )
 
-// сколько threads для одной команды ffmpeg
-const numThreads = "4"
-
 // Функция возвращает путь к ffmpeg
-func Ffmpeg() string {
+func Ffmpeg() (string, error) {
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err != nil {
-		log.Fatal(
-			"ffmpeg not found. Please make sure ffmpeg is installed and added to your system PATH.",
+		return "", fmt.Errorf(
+			"ffmpeg not found. Please make sure ffmpeg is installed and added to your system PATH: %w",
+			ErrToolNotFound,
 		)
 	}
-	return ffmpegPath
+	return ffmpegPath, nil
 }
 
-func setArguments(russianAudioIndex string, englishAudioIndex string, russianSubtitleIndex string, englishSubtitleIndex string, inputFile string, outputFile string) ([]string, error) {
-	if russianAudioIndex == "-1" && englishAudioIndex == "-1" {
-		return nil, fmt.Errorf("Can't convert because both audio indexes in %s are undefined:\n\trussianAudioIndex = %s\n\tenglishAudioIndex = %s\n\trussianSubtitleIndex = %s\n\tenglishSubtitleIndex = %s\n\t", inputFile, russianAudioIndex, englishAudioIndex, russianSubtitleIndex, englishSubtitleIndex)
-
-		// TODO: очистить ресурсы?
-	}
-
-	res := make([]string, 0)
-	res = append(res, "-i")
-	res = append(res, inputFile)
-	res = append(res, "-c:v")
-	res = append(res, "libx265")
-	// res = append(res, "-threads")
-	// res = append(res, "numThreads")
-	res = append(res, "-crf")
-	res = append(res, "23")
-	res = append(res, "-vf")
-	res = append(res, "scale=-2:720")
-
-	// предполагаем что хоть одна аудиодорожка есть
-	if russianAudioIndex == "-1" || englishAudioIndex == "-1" {
-		res = append(res, "-c:a:0")
-		res = append(res, "copy")
-	} else {
-		res = append(res, "-c:a:0")
-		res = append(res, "copy")
-		res = append(res, "-c:a:1")
-		res = append(res, "copy")
-	}
-
-	switch {
-	case russianSubtitleIndex == "-1" && englishSubtitleIndex != "-1", russianSubtitleIndex != "-1" && englishSubtitleIndex == "-1":
-		{
-			res = append(res, "-c:s:0")
-			res = append(res, "copy")
-
-		}
-	case russianSubtitleIndex == "-1" && englishSubtitleIndex == "-1":
-
-	default:
-		{
-			res = append(res, "-c:s:0")
-			res = append(res, "copy")
-			res = append(res, "-c:s:1")
-			res = append(res, "copy")
-		}
+// Собираем команду ffmpeg для выбранных дорожек и профиля
+func buildCommand(sel Selection, profile Profile, remux bool, inputFile string, outputFile string) (*Command, error) {
+	if len(sel.Audio) == 0 {
+		return nil, &NoAudioError{File: inputFile}
+	}
+	if sel.Video.TypeIndex < 0 {
+		return nil, &NoVideoError{File: inputFile}
 	}
 
-	res = append(res, "-map")
-	res = append(res, "0:v:0")
+	format, err := MuxerFor(outputFile)
+	if err != nil {
+		return nil, err
+	}
+	cmd := &Command{Output: outputFile, Format: format}
+	// прогресс пишется в stdout в виде key=value, обычная статистика в stderr не нужна.
+	// -nostdin: ffmpeg не читает клавиши из терминала и не останавливается, работая в фоне
+	cmd.SetGlobal("nostdin", "").SetGlobal("hide_banner", "").SetGlobal("nostats", "").SetGlobal("progress", "pipe:1")
+	in := cmd.AddInput(inputFile)
+
+	video := cmd.AddStream(StreamRef{Input: in, Type: StreamVideo, Index: sel.Video.TypeIndex}, "copy")
+	if !remux {
+		profile.configureVideo(video, ScaleFor(sel.Video, profile.Height))
+	}
 
-	switch {
-	case russianAudioIndex != "-1" && englishAudioIndex == "-1":
-		{
-			res = append(res, "-map")
-			res = append(res, "0:a:"+russianAudioIndex)
-		}
-	case russianAudioIndex == "-1" && englishAudioIndex != "-1":
-		{
-			res = append(res, "-map")
-			res = append(res, "0:a:"+englishAudioIndex)
-		}
-	case russianAudioIndex != "-1" && englishAudioIndex != "-1":
-		{
-			res = append(res, "-map")
-			res = append(res, "0:a:"+russianAudioIndex)
-			res = append(res, "-map")
-			res = append(res, "0:a:"+englishAudioIndex)
+	// в -map используем индекс среди потоков своего типа (0:a:N, 0:s:N)
+	for _, a := range sel.Audio {
+		s := cmd.AddStream(StreamRef{Input: in, Type: StreamAudio, Index: a.TypeIndex}, "copy")
+		// комментарии помечаем флагом, чтобы плеер не выбирал их по умолчанию
+		if a.Kind == AudioCommentary {
+			s.Flag("comment")
 		}
 	}
-	switch {
-	case russianSubtitleIndex != "-1" && englishSubtitleIndex == "-1":
-		{
-			res = append(res, "-map")
-			res = append(res, "0:s:"+russianSubtitleIndex)
-		}
-	case russianSubtitleIndex == "-1" && englishSubtitleIndex != "-1":
-		{
-			res = append(res, "-map")
-			res = append(res, "0:s:"+englishSubtitleIndex)
-		}
-	case russianSubtitleIndex == "-1" && englishSubtitleIndex == "-1":
-	default:
-		{
-			res = append(res, "-map")
-			res = append(res, "0:s:"+russianSubtitleIndex)
-			res = append(res, "-map")
-			res = append(res, "0:s:"+englishSubtitleIndex)
+	for _, sub := range sel.Subs {
+		s := cmd.AddStream(StreamRef{Input: in, Type: StreamSubtitle, Index: sub.TypeIndex}, "copy")
+		// форсированные субтитры помечаем флагом, чтобы плеер включал их сам
+		if sub.Kind == SubsForced {
+			s.Flag("forced")
 		}
 	}
 
-	res = append(res, outputFile)
+	return cmd, nil
+}
 
-	return res, nil
+func setArguments(sel Selection, profile Profile, remux bool, inputFile string, outputFile string) ([]string, error) {
+	cmd, err := buildCommand(sel, profile, remux, inputFile, outputFile)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Args(), nil
+}
+
+// Задание на конвертацию одного файла
+type Job struct {
+	Input      string
+	Output     string
+	Selection  Selection
+	Profile    Profile
+	Decision   Decision
+	FullDecode bool // после кодирования полностью декодировать результат для проверки
+}
+
+// Каким должен получиться результат задания
+func (j Job) Expected() Expectation {
+	height := j.Selection.Video.Height
+	if !j.Decision.Remux {
+		height = ScaleFor(j.Selection.Video, j.Profile.Height).Height
+	}
+	return ExpectedOutput(j.Selection, height)
 }
 
-func ConvertFile(
-	ffmpegPath string,
-	inputFile string,
-	outputFile string,
-	russianAudioIndex string,
-	englishAudioIndex string,
-	russianSubtitleIndex string,
-	englishSubtitleIndex string,
-) error {
+// Аргументы ffmpeg, с которыми ConvertFile запустит задачу. Вывод идет во временный файл,
+// поэтому команда совпадает с реальной до последнего аргумента
+func (j Job) Args() ([]string, error) {
 	// Формируем команду ffmpeg для сохранения выбранных потоков и субтитров
-	args, err := setArguments(russianAudioIndex, englishAudioIndex, russianSubtitleIndex, englishSubtitleIndex, inputFile, outputFile)
+	cmd, err := buildCommand(j.Selection, j.Profile, j.Decision.Remux, j.Input, j.Output)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	// fmt.Printf("Args for ffmeg = %v\n", args)
-	cmd := exec.Command(ffmpegPath, args...)
+	cmd.Output = PartialName(j.Output)
+	return cmd.Args(), nil
+}
 
-	// Запускаем команду и выводим результат
-	_, err = cmd.CombinedOutput()
+// Конвертируем файл. onProgress, если не nil, вызывается при каждом обновлении прогресса ffmpeg.
+// ffmpeg пишет во временный файл рядом с job.Output, который переименовывается в job.Output
+// только после успешного кодирования и проверки результата. При ошибке или отмене ctx
+// временный файл удаляется, так что на месте job.Output не бывает недописанного файла
+func ConvertFile(ctx context.Context, ffmpegPath string, job Job, onProgress func(Progress)) error {
+	args, err := job.Args()
 	if err != nil {
-		log.Printf("Error converting file %s: %v\n", inputFile, err)
-		log.Printf("File %s is removing\n", outputFile)
-		err2 := os.Remove(outputFile)
-		if err2 != nil {
-			return fmt.Errorf("1. Ошибка при конвертировании файла %w\n2.Ошибка при удалении файла: %w", err, err2)
+		return err
+	}
+	partial := PartialName(job.Output)
+
+	if err := ensureDir(job.Output); err != nil {
+		return err
+	}
+	// временный файл мог остаться после падения, ffmpeg без -y его не перезапишет
+	if err := removeIfExists(partial); err != nil {
+		return err
+	}
+
+	progress := Progress{File: job.Input, Duration: job.Selection.Video.Duration}
+	err = runFfmpeg(ctx, ffmpegPath, args, job.Input, progress, onProgress)
+	if err == nil {
+		err = finishOutput(ctx, ffmpegPath, partial, job)
+	}
+	if err != nil {
+		// недописанный или непроверенный файл не должен остаться на диске
+		if err2 := removeIfExists(partial); err2 != nil {
+			return errors.Join(err, err2)
+		}
+		return err
+	}
+	return nil
+}
+
+// Запускаем ffmpeg и разбираем прогресс, пока он работает
+func runFfmpeg(ctx context.Context, ffmpegPath string, args []string, inputFile string, progress Progress, onProgress func(Progress)) error {
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	ownProcessGroup(cmd)
+	// если после отмены кто-то держит открытым вывод ffmpeg, долго не ждем
+	cmd.WaitDelay = 5 * time.Second
+
+	// stderr нужен только для сообщения об ошибке, храним его хвост
+	stderr := newTailBuffer(64 * 1024)
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return &EncodeError{File: inputFile, Err: err}
+	}
+
+	err = cmd.Start()
+	if err == nil {
+		// ошибку чтения игнорируем: результат все равно определяет Wait
+		_ = parseProgress(stdout, progress, onProgress)
+		err = cmd.Wait()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// процесс убит из-за отмены, его вывод ничего не объясняет
+			return &EncodeError{File: inputFile, Err: ctx.Err()}
+		}
+		return &EncodeError{File: inputFile, Err: err, Stderr: tailLines(stderr.Bytes(), stderrTailLines)}
+	}
+	return nil
+}
+
+// Сбрасываем временный файл на диск, проверяем его и переименовываем в job.Output
+func finishOutput(ctx context.Context, ffmpegPath string, partial string, job Job) error {
+	if err := syncFile(partial); err != nil {
+		return err
+	}
+
+	state, reason, err := VerifyOutput(ctx, ffmpegPath, partial, job)
+	if err != nil {
+		return err
+	}
+	if state != OutputValid {
+		if reason == "" {
+			reason = "output is " + state.String()
 		}
-		return fmt.Errorf("1. Ошибка при конвертировании файла %w\n", err)
+		return &VerifyError{File: job.Output, Reason: reason}
+	}
+
+	if err := os.Rename(partial, job.Output); err != nil {
+		return fmt.Errorf("Ошибка при переименовании файла %s: %w", partial, err)
 	}
+	// переименование тоже должно пережить отключение питания
+	syncDir(filepath.Dir(job.Output))
 	return nil
 }
@@ -1,84 +1,309 @@
 package utils
 
 import (
-	// "reflect"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
+// go test ./utils -run Golden -update перезаписывает файлы в testdata/args
+var update = flag.Bool("update", false, "update golden files in testdata/args")
+
 func TestSetArguments(t *testing.T) {
 
-	// Test case 1: Both audio indexes are undefined
-	expectedError := fmt.Sprintf("Can't convert because both audio indexes in %s are undefined:\n\trussianAudioIndex = %s\n\tenglishAudioIndex = %s\n\trussianSubtitleIndex = %s\n\tenglishSubtitleIndex = %s\n\t", "input.mkv", "-1", "-1", "0", "1")
-	_, err := setArguments("-1", "-1", "0", "1", "input.mkv", "output.mkv")
+	// Test case 1: No audio tracks selected
+	expectedError := fmt.Sprintf("Can't convert because no audio track in %s matches the preferred languages", "input.mkv")
+	_, err := setArguments(selection(nil, []int{0, 1}), DefaultProfile(), false, "input.mkv", "output.mkv")
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("Expected error: %s, but got: %v", expectedError, err)
+	}
+
+	var noAudio *NoAudioError
+	if !errors.As(err, &noAudio) {
+		t.Errorf("Ожидалась NoAudioError, получено %T", err)
+	}
+
+	// Test case 2: Neither audio nor subtitles selected
+	_, err = setArguments(selection(nil, nil), DefaultProfile(), false, "input.mkv", "output.mkv")
 	if err == nil || err.Error() != expectedError {
 		t.Errorf("Expected error: %s, but got: %v", expectedError, err)
 	}
 
-	// Test case 2: Both audio and subtitles indexes are undefined
-	expectedError = fmt.Sprintf("Can't convert because both audio indexes in %s are undefined:\n\trussianAudioIndex = %s\n\tenglishAudioIndex = %s\n\trussianSubtitleIndex = %s\n\tenglishSubtitleIndex = %s\n\t", "input.mkv", "-1", "-1", "-1", "-1")
-	_, err = setArguments("-1", "-1", "-1", "-1", "input.mkv", "output.mkv")
+	// Test case 3: No video stream
+	sel := selection([]int{0}, nil)
+	sel.Video = VideoInfo{TypeIndex: -1}
+	expectedError = fmt.Sprintf("Can't convert because there is no video stream in %s", "input.mkv")
+	_, err = setArguments(sel, DefaultProfile(), false, "input.mkv", "output.mkv")
 	if err == nil || err.Error() != expectedError {
 		t.Errorf("Expected error: %s, but got: %v", expectedError, err)
 	}
+	var noVideo *NoVideoError
+	if !errors.As(err, &noVideo) {
+		t.Errorf("Ожидалась NoVideoError, получено %T", err)
+	}
+}
+
+func TestSetArgumentsGolden(t *testing.T) {
+	forced := selection([]int{1, 0}, []int{2, 0})
+	forced.Audio[1].Kind = AudioCommentary
+	forced.Subs[1].Kind = SubsForced
+
+	anamorphic := selection([]int{0}, nil)
+	anamorphic.Video = VideoInfo{TypeIndex: 1, Width: 720, Height: 576, SAR: "64:45", DAR: "16:9"}
+
+	remux := selection([]int{0, 1}, []int{0})
+	remux.Video = VideoInfo{Codec: "hevc", Width: 1280, Height: 720}
+
+	anime := Profile{Codec: "libx264", CRF: intPtr(20), Preset: "slow", Tune: "animation", Height: 720, PixFmt: "yuv420p"}
+
+	hevcThreads := DefaultProfile()
+	hevcThreads.Threads = 8
+	vp9Threads := Profile{Codec: "libvpx-vp9", CRF: intPtr(31), Height: 720, Threads: 4}
+
+	tests := []struct {
+		name    string
+		sel     Selection
+		profile Profile
+		remux   bool
+	}{
+		// прежние комбинации рус/англ аудио и субтитров
+		{"two-audio-no-subs", selection([]int{0, 1}, nil), DefaultProfile(), false},
+		{"one-audio-two-subs", selection([]int{0}, []int{0, 1}), DefaultProfile(), false},
+		{"two-audio-two-subs", selection([]int{0, 1}, []int{0, 1}), DefaultProfile(), false},
+		{"two-audio-one-subs", selection([]int{0, 1}, []int{0}), DefaultProfile(), false},
+
+		// третья дорожка и порядок по предпочтению, а не по номеру в файле
+		{"three-audio-order", selection([]int{2, 0, 1}, []int{3}), DefaultProfile(), false},
+		{"dispositions", forced, DefaultProfile(), false},
+		{"no-upscale", anamorphic, DefaultProfile(), false},
+		{"remux", remux, DefaultProfile(), true},
+		{"profile-x264-anime", selection([]int{0}, []int{0}), anime, false},
+
+		// ограничение потоков от планировщика
+		{"threads-x265", selection([]int{0}, nil), hevcThreads, false},
+		{"threads-vp9", selection([]int{0}, nil), vp9Threads, false},
+		{"threads-remux", remux, hevcThreads, true},
+	}
+
+	for _, test := range tests {
+		actualArgs, err := setArguments(test.sel, test.profile, test.remux, "input.mkv", "output.mkv")
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.name, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", "args", test.name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, []byte(strings.Join(actualArgs, "\n")+"\n"), 0644); err != nil {
+				t.Fatalf("Ошибка при обновлении эталона %s: %v", golden, err)
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("Ошибка при чтении эталона %s: %v", golden, err)
+		}
+		expectedArgs := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		if !reflect.DeepEqual(expectedArgs, actualArgs) {
+			t.Errorf("%s: ожидалось %v, получено %v", test.name, expectedArgs, actualArgs)
+		}
+	}
+}
+
+func TestConvertFileEncodeError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	// вместо ffmpeg запускаем команду, которая пишет в вывод и завершается с ошибкой
+	dir := t.TempDir()
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho 'Stream mapping:' >&2\necho 'Conversion failed!' >&2\ntouch \"$(eval echo \\${$#})\"\nexit 1\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
+	}
+	output := filepath.Join(dir, "output.mkv")
+
+	err := ConvertFile(context.Background(), fakeFfmpeg, Job{Input: "input.mkv", Output: output, Selection: selection([]int{0}, nil), Profile: DefaultProfile()}, nil)
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) {
+		t.Fatalf("Ожидалась EncodeError, получено %v", err)
+	}
+	if encodeErr.Stderr != "Stream mapping:\nConversion failed!" {
+		t.Errorf("Неожиданный хвост stderr: %q", encodeErr.Stderr)
+	}
+	assertNoOutput(t, output)
+}
 
-	// "-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-	// "-c:a:0", "copy", "-c:a:1", "copy", "-c:s:0", "copy", "-c:s:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "-map", "0:s:0", "-map", "0:s:1", "output.mkv"
+func TestConvertFileProgress(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	progress, err := filepath.Abs("testdata/progress.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\ncat '" + progress + "'\ntouch \"$(eval echo \\${$#})\"\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
+	}
+	fakeFfprobe(t, dir, 1, 120)
 
-	// Test case 3: Both subtitle indexes are undefined
-	expectedArgs := []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:a:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "output.mkv"}
-	actualArgs, _ := setArguments("0", "1", "-1", "-1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+	sel := selection([]int{0}, nil)
+	sel.Video.Duration = 2 * time.Minute
+	updates := make([]Progress, 0)
+	err = ConvertFile(context.Background(), fakeFfmpeg, Job{Input: "input.mkv", Output: filepath.Join(dir, "output.mkv"), Selection: sel, Profile: DefaultProfile()}, func(p Progress) {
+		updates = append(updates, p)
+	})
+	if err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
 	}
+	if len(updates) != 3 || updates[1].Percent() != 50 || !updates[2].Done {
+		t.Errorf("Неожиданный прогресс: %+v", updates)
+	}
+}
 
-	// Test case 4: Only Russian audio index is defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:s:0", "copy", "-c:s:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:s:0", "-map", "0:s:1", "output.mkv"}
-	actualArgs, _ = setArguments("0", "-1", "0", "1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+func TestConvertFileCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
 	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mkv")
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	// пишет выходной файл и "кодирует" долго
+	script := "#!/bin/sh\ntouch '" + PartialName(output) + "'\nexec sleep 30\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// ждем, пока появится выходной файл, и отменяем
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(PartialName(output)); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		cancel()
+	}()
 
-	// Test case 5: Only English audio index is defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:s:0", "copy", "-c:s:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:s:0", "-map", "0:s:1", "output.mkv"}
-	actualArgs, _ = setArguments("-1", "0", "0", "1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+	err := ConvertFile(ctx, fakeFfmpeg, Job{Input: "input.mkv", Output: output, Selection: selection([]int{0}, nil), Profile: DefaultProfile()}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Ожидалась context.Canceled, получено %v", err)
 	}
+	assertNoOutput(t, output)
+}
 
-	// Test case 6: Both audio indexes are defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:a:1", "copy", "-c:s:0", "copy", "-c:s:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "-map", "0:s:0", "-map", "0:s:1", "output.mkv"}
-	actualArgs, _ = setArguments("0", "1", "0", "1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+func TestConvertFileAtomicRename(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mkv")
+	// ffmpeg должен писать во временный файл с явным форматом
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho \"$@\" > '" + filepath.Join(dir, "args") + "'\ntouch \"$(eval echo \\${$#})\"\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
 	}
+	// от прошлого запуска остались недописанный временный файл и старый результат
+	for _, name := range []string{PartialName(output), output} {
+		if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakeFfprobe(t, dir, 1, 60)
 
-	// Test case 7: Only Russian subtitle index is defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:a:1", "copy", "-c:s:0", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "-map", "0:s:0", "output.mkv"}
-	actualArgs, _ = setArguments("0", "1", "0", "-1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+	sel := selection([]int{0}, nil)
+	sel.Video.Duration = time.Minute
+	if err := ConvertFile(context.Background(), fakeFfmpeg, Job{Input: "input.mkv", Output: output, Selection: sel, Profile: DefaultProfile()}, nil); err != nil {
+		t.Fatalf("Неожиданная ошибка: %v", err)
 	}
 
-	// Test case 8: Only English subtitle index is defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:a:1", "copy", "-c:s:0", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "-map", "0:s:0", "output.mkv"}
-	actualArgs, _ = setArguments("0", "1", "-1", "0", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(string(args)), "-f matroska "+PartialName(output)) {
+		t.Errorf("Ожидался вывод во временный файл, аргументы: %s", args)
 	}
+	if data, err := os.ReadFile(output); err != nil || len(data) != 0 {
+		t.Errorf("Результат должен быть заменен новым файлом, получено %q (%v)", data, err)
+	}
+	if _, err := os.Stat(PartialName(output)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Временный файл должен быть переименован, ошибка stat: %v", err)
+	}
+}
 
-	// Test case 9: Both subtitle indexes are defined
-	expectedArgs = []string{"-i", "input.mkv", "-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720",
-		"-c:a:0", "copy", "-c:a:1", "copy", "-c:s:0", "copy", "-c:s:1", "copy", "-map", "0:v:0", "-map", "0:a:0", "-map", "0:a:1", "-map", "0:s:0", "-map", "0:s:1", "output.mkv"}
-	actualArgs, _ = setArguments("0", "1", "0", "1", "input.mkv", "output.mkv")
-	if !reflect.DeepEqual(expectedArgs, actualArgs) {
-		t.Errorf("Expected: %v, but got: %v", expectedArgs, actualArgs)
+func TestConvertFileProbeCheckFailed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mkv")
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\ntouch \"$(eval echo \\${$#})\"\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
+	}
+	// ffmpeg завершился успешно, но файл обрывается на середине
+	fakeFfprobe(t, dir, 1, 30)
+
+	sel := selection([]int{0}, nil)
+	sel.Video.Duration = time.Minute
+	err := ConvertFile(context.Background(), fakeFfmpeg, Job{Input: "input.mkv", Output: output, Selection: sel, Profile: DefaultProfile()}, nil)
+	var verifyErr *VerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("Ожидалась VerifyError, получено %v", err)
+	}
+	assertNoOutput(t, output)
+}
+
+// Ни результата, ни временного файла не должно остаться
+func assertNoOutput(t *testing.T, output string) {
+	t.Helper()
+	for _, name := range []string{output, PartialName(output)} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Недописанный файл %s должен быть удален, ошибка stat: %v", name, err)
+		}
+	}
+}
+
+// Кладем в PATH ffprobe, который для любого файла сообщает о видео, audio аудиодорожках
+// и длительности seconds
+func fakeFfprobe(t *testing.T, dir string, audio int, seconds int) {
+	t.Helper()
+	streams := []string{`{"index":0,"codec_type":"video","codec_name":"hevc","width":1280,"height":720}`}
+	for i := 0; i < audio; i++ {
+		streams = append(streams, fmt.Sprintf(`{"index":%d,"codec_type":"audio","codec_name":"aac"}`, i+1))
+	}
+	probe := fmt.Sprintf(`{"streams":[%s],"format":{"duration":"%d.000000"}}`, strings.Join(streams, ","), seconds)
+	script := "#!/bin/sh\ncat <<'EOF'\n" + probe + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte(script), 0755); err != nil {
+		t.Fatalf("Ошибка при создании фейкового ffprobe: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Собираем Selection по индексам 0:a:N и 0:s:N
+func selection(audio []int, subs []int) Selection {
+	sel := Selection{}
+	for _, i := range audio {
+		sel.Audio = append(sel.Audio, AudioInfo{TypeIndex: i})
+	}
+	for _, i := range subs {
+		sel.Subs = append(sel.Subs, SubsInfo{TypeIndex: i})
 	}
+	return sel
 }
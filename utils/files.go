@@ -1,105 +1,154 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
-	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-type (
-	dirFiles []fs.DirEntry
-)
-
-// Получаем все файлы из директории с нужным расширением
-func GetFiles(extn string) []fs.DirEntry {
-	files := make(dirFiles, 0)
-
-	fl, err := os.ReadDir(".")
-	if err != nil {
-		log.Fatalf("Error reading directory: %v", err)
-	}
-
-	for _, f := range fl {
-		if f.IsDir() {
-			continue
-		}
-		if ext := filepath.Ext(f.Name()); ext == extn {
-			files = append(files, f)
-		}
-	}
-
-	return files
-}
-
 // Читаем файл и делим на строки
-func ReadFileAndSplit(filename string) []string {
+func ReadFileAndSplit(filename string) ([]string, error) {
 	// Читаем содержимое файла
 	content, err := os.ReadFile(filename)
 
 	if err != nil {
-		log.Fatalf("Ошибка при чтении файла ошибок: %s\n", err)
+		return nil, fmt.Errorf("Ошибка при чтении файла %s: %w", filename, err)
 	}
 
 	// Разделяем содержимое на строки
 	lines := strings.Split(string(content), "\n")
-	return lines
+	return lines, nil
 }
 
-// функция для изменения имени файла - оставляем только название и номер_сезона.номер_серии
-func SplitFileNameByPattern(filename string) (string, error) {
+// функция для изменения имени файла - оставляем только название и номер_сезона.номер_серии,
+// desc - суффикс профиля кодирования, например ".720p.H265"
+func SplitFileNameByPattern(filename string, desc string) string {
 	// 1. Yellowstone S03E01 WEB-DL 2160p.mkv			=> Yellowstone S03E01.720p.H265.mkv
 	// 2. 01x00 Pilot [CBS Drama+OPT+Eng].mkv          	=> S01E00.Pilot.720p.H265.mkv
 	// 3. 01. The One Where Monica Gets a Roommate.mkv 	=> E01.The One Where Monica Gets a Roommate.720p.H265.mkv
+	// 4. [Group] Show - 123 [1080p].mkv, Show.2023.05.14.mkv, Show.S01.E01.mkv => по разбору имени:
+	//    Show - 123.720p.H265.mkv, Show 2023-05-14.720p.H265.mkv, Show S01E01.720p.H265.mkv
+	// 5. все остальное                                  => исходное имя с суффиксом: Pilot.720p.H265.mkv
+	ext := filepath.Ext(filename)
 
-	const (
-		desc = ".720p.H265"
-	)
-
-	// Паттерн 1: ([sS]\d\d[eE]\d\d-?\d?\d?)
-	pattern1 := regexp.MustCompile(`([sS]\d\d[eE]\d\d-?\d?\d?)`)
+	// Паттерн 1: ([sS]\d\d[eE]\d\d), несколько серий: S01E01-02, S01E01E02, S01E01-E02
+	pattern1 := regexp.MustCompile(`([sS]\d\d[eE]\d\d(?:-?[eE]\d\d|-\d\d)*)`)
 	matches := pattern1.FindStringSubmatchIndex(filename)
 	if len(matches) > 0 {
-		ext := filepath.Ext(filename)
-		return filename[:matches[0]] + filename[matches[2]:matches[3]] + desc + ext, nil
+		return filename[:matches[0]] + filename[matches[2]:matches[3]] + desc + ext
 	}
 
 	// Паттерн 2: (\d\d)x(\d\d)\s*(.*)\s*\[.*
 	pattern2 := regexp.MustCompile(`(\d\d)x(\d\d)\s*(.*)\s*\[.*`)
 	matches = pattern2.FindStringSubmatchIndex(filename)
 	if len(matches) > 0 {
-		ext := filepath.Ext(filename)
-		return "S" + filename[matches[2]:matches[3]] + "E" + filename[matches[4]:matches[5]] + "." + strings.TrimSpace(filename[matches[6]:matches[7]]) + desc + ext, nil
+		return "S" + filename[matches[2]:matches[3]] + "E" + filename[matches[4]:matches[5]] + "." + strings.TrimSpace(filename[matches[6]:matches[7]]) + desc + ext
 	}
 
-	// Паттерн 3: (\d\d)\.\s*(.*)\..*
-	pattern3 := regexp.MustCompile(`(\d\d)\.\s*(.*)\..*`)
+	// Паттерн 3: ^(\d\d)\.\s*(.*)\..* - только в начале имени, иначе под него попадают даты
+	pattern3 := regexp.MustCompile(`^(\d\d)\.\s*(.*)\..*`)
 	matches = pattern3.FindStringSubmatchIndex(filename)
 	if len(matches) > 0 {
-		ext := filepath.Ext(filename)
-		return "E" + filename[matches[2]:matches[3]] + "." + strings.TrimSpace(filename[matches[4]:matches[5]]) + desc + ext, nil
+		return "E" + filename[matches[2]:matches[3]] + "." + strings.TrimSpace(filename[matches[4]:matches[5]]) + desc + ext
+	}
+
+	if episode, err := ParseEpisodeName(filename); err == nil {
+		if name := sanitizeName(episode.ShortName()); name != "" {
+			return name + desc + ext
+		}
 	}
 
-	return "", fmt.Errorf("ни один из паттернов не найден в имени файла: %s", filename)
+	// имя не разобрать - оставляем исходное, чтобы не пропускать файл
+	return strings.TrimSuffix(filename, ext) + desc + ext
 }
 
 // функция для создания файла по имени
-func CreateFile(name string) *os.File {
+func CreateFile(name string) (*os.File, error) {
 	outputFile, err := os.Create(name)
 	if err != nil {
-		log.Fatalf("Ошибка при создании файла %s: %s\n", name, err)
+		return nil, fmt.Errorf("Ошибка при создании файла %s: %w", name, err)
 	}
-	return outputFile
+	return outputFile, nil
 }
 
 // функция для закрытия и удаления файла
-func RemoveFile(name string, file *os.File) {
+func RemoveFile(name string, file *os.File) error {
 	file.Close()
 	err := os.Remove(name)
 	if err != nil {
-		fmt.Printf("Ошибка при удалении файла %s: %v", name, err)
+		return fmt.Errorf("Ошибка при удалении файла %s: %w", name, err)
+	}
+	return nil
+}
+
+// Суффикс временного файла, в который пишет ffmpeg до успешного завершения
+const partialSuffix = ".partial"
+
+// Имя временного файла для outputFile: в том же каталоге, чтобы переименование было атомарным
+func PartialName(outputFile string) string {
+	return outputFile + partialSuffix
+}
+
+// Формат ffmpeg (-f) по расширению выходного файла: у временного файла расширение
+// .partial, по нему ffmpeg формат не угадает
+var muxers = map[string]string{
+	".mkv":  "matroska",
+	".mka":  "matroska",
+	".mp4":  "mp4",
+	".m4v":  "mp4",
+	".mov":  "mov",
+	".webm": "webm",
+}
+
+func MuxerFor(outputFile string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(outputFile))
+	muxer, ok := muxers[ext]
+	if !ok {
+		return "", fmt.Errorf("неизвестный формат выходного файла %s", outputFile)
+	}
+	return muxer, nil
+}
+
+// Создаем каталог для выходного файла
+func ensureDir(outputFile string) error {
+	dir := filepath.Dir(outputFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Ошибка при создании каталога %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Удаляем файл, если он есть
+func removeIfExists(name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Ошибка при удалении файла %s: %w", name, err)
+	}
+	return nil
+}
+
+// Сбрасываем содержимое файла на диск
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("Ошибка при открытии файла %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("Ошибка при записи файла %s на диск: %w", name, err)
+	}
+	return nil
+}
+
+// Сбрасываем на диск каталог после переименования. Не везде каталог можно
+// открыть и синхронизировать (Windows), поэтому ошибки игнорируем
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
 	}
+	defer d.Close()
+	_ = d.Sync()
 }
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -16,7 +17,10 @@ func TestReadFileAndSplit(t *testing.T) {
 	defer os.Remove(tmpFile)
 
 	// Вызываем тестируемую функцию
-	lines := ReadFileAndSplit(tmpFile)
+	lines, err := ReadFileAndSplit(tmpFile)
+	if err != nil {
+		t.Fatalf("Ошибка при чтении файла: %s", err)
+	}
 
 	// Проверяем результат
 	expectedLines := []string{"line 1", "line 2", "line 3"}
@@ -25,6 +29,12 @@ func TestReadFileAndSplit(t *testing.T) {
 	}
 }
 
+func TestReadFileAndSplitMissing(t *testing.T) {
+	if _, err := ReadFileAndSplit("missing-file.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Ожидалась ошибка os.ErrNotExist, получено %v", err)
+	}
+}
+
 func equalSlices(slice1, slice2 []string) bool {
 	if len(slice1) != len(slice2) {
 		return false
@@ -40,27 +50,64 @@ func equalSlices(slice1, slice2 []string) bool {
 func TestSplitFileNameByPattern(t *testing.T) {
 	tests := []struct {
 		input    string
+		desc     string
 		expected string
 	}{
 		{
 			input:    "Yellowstone S03E01 WEB-DL 2160p.mkv",
+			desc:     ".720p.H265",
 			expected: "Yellowstone S03E01.720p.H265.mkv",
 		},
 		{
 			input:    "01x00 Pilot [CBS Drama+OPT+Eng].mkv",
+			desc:     ".720p.H265",
 			expected: "S01E00.Pilot.720p.H265.mkv",
 		},
 		{
 			input:    "01. The One Where Monica Gets a Roommate.mkv",
+			desc:     ".720p.H265",
 			expected: "E01.The One Where Monica Gets a Roommate.720p.H265.mkv",
 		},
+		{
+			input:    "Yellowstone S03E01 WEB-DL 2160p.mkv",
+			desc:     ".1080p.AV1",
+			expected: "Yellowstone S03E01.1080p.AV1.mkv",
+		},
+		{
+			input:    "Show S01E01E02.mkv",
+			desc:     ".720p.H265",
+			expected: "Show S01E01E02.720p.H265.mkv",
+		},
+		{
+			input:    "Show 1x01-1x02.mkv",
+			desc:     ".720p.H265",
+			expected: "Show S01E01-E02.720p.H265.mkv",
+		},
+		{
+			input:    "Show.S01.E01.mkv",
+			desc:     ".720p.H265",
+			expected: "Show S01E01.720p.H265.mkv",
+		},
+		{
+			input:    "Show.2023.05.14.mkv",
+			desc:     ".720p.H265",
+			expected: "Show 2023-05-14.720p.H265.mkv",
+		},
+		{
+			input:    "[Group] Show - 123 [1080p].mkv",
+			desc:     ".720p.H265",
+			expected: "Show - 123.720p.H265.mkv",
+		},
+		{
+			// имя не разобрать - остается исходное
+			input:    "Pilot.mkv",
+			desc:     ".720p.H265",
+			expected: "Pilot.720p.H265.mkv",
+		},
 	}
 
 	for _, test := range tests {
-		result, err := SplitFileNameByPattern(test.input)
-		if err != nil {
-			t.Errorf("Ошибка при обработке файла: %v", err)
-		}
+		result := SplitFileNameByPattern(test.input, test.desc)
 		if result != test.expected {
 			t.Errorf("Для входного значения %q, ожидался результат %q, получено %q", test.input, test.expected, result)
 		}
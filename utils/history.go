@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Статус успешно сконвертированного файла в истории
+const HistoryDone = "done"
+
+// Одна задача в истории конвертаций
+type HistoryRecord struct {
+	Time       time.Time `json:"time"` // когда задача закончилась
+	Input      string    `json:"input"`
+	Hash       string    `json:"hash,omitempty"` // FileHash исходника
+	Output     string    `json:"output,omitempty"`
+	Show       string    `json:"show,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Elapsed    float64   `json:"elapsed"` // время кодирования, секунды
+	SourceSize int64     `json:"source_size,omitempty"`
+	OutputSize int64     `json:"output_size,omitempty"`
+}
+
+// История всех запусков: файл JSON Lines, по записи на задачу.
+// Записи только дописываются в конец, так что несколько запусков могут писать в один файл
+type History struct {
+	path    string
+	mu      sync.Mutex
+	records []HistoryRecord
+	outputs map[string]bool // готовые результаты, для IsOutput
+	skipped []error         // битые строки, пропущенные при чтении
+}
+
+// Читаем историю из файла. Файла нет - история пустая, он создастся при первой записи.
+// Битые строки пропускаются и попадают в Skipped, ошибка - только если файл не прочитать
+func OpenHistory(path string) (*History, error) {
+	h := &History{path: path, outputs: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении истории %s: %w", path, err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	// после последнего перевода строки - пусто или недописанная запись упавшего запуска
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			h.skipped = append(h.skipped, &HistoryLineError{Path: path, Line: i + 1, Err: err})
+			continue
+		}
+		h.append(rec)
+	}
+	return h, nil
+}
+
+func (h *History) Path() string {
+	return h.path
+}
+
+// Строки, пропущенные при чтении истории, - *HistoryLineError
+func (h *History) Skipped() []error {
+	return h.skipped
+}
+
+// Дописываем запись в файл истории
+func (h *History) Add(rec HistoryRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return fmt.Errorf("Ошибка при создании каталога истории: %w", err)
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Ошибка при записи истории %s: %w", h.path, err)
+	}
+	// запись одним write, чтобы строки параллельных запусков не перемешались
+	_, err = f.Write(append(data, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("Ошибка при записи истории %s: %w", h.path, err)
+	}
+	h.append(rec)
+	return nil
+}
+
+func (h *History) append(rec HistoryRecord) {
+	h.records = append(h.records, rec)
+	if rec.Status == HistoryDone && rec.Output != "" {
+		h.outputs[rec.Output] = true
+	}
+}
+
+// Записан ли файл в истории как готовый результат. Так узнаются результаты,
+// разложенные по шаблону рядом с исходниками, где их не отличить по имени
+func (h *History) IsOutput(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.outputs[abs]
+}
+
+// Последняя успешная конвертация исходника с таким хэшем в профиле profile
+func (h *History) Converted(hash, profile string) (HistoryRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.records) - 1; i >= 0; i-- {
+		rec := h.records[i]
+		if rec.Hash == hash && rec.Profile == profile && rec.Status == HistoryDone {
+			return rec, true
+		}
+	}
+	return HistoryRecord{}, false
+}
+
+// Условия выборки из истории, пустые поля не проверяются
+type HistoryFilter struct {
+	Status string    // точное совпадение: failed, done
+	Since  time.Time // не раньше этого времени
+	Show   string    // часть названия сериала или пути исходника, без учета регистра
+}
+
+func (f HistoryFilter) matches(rec HistoryRecord) bool {
+	if f.Status != "" && !strings.EqualFold(rec.Status, f.Status) {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if f.Show != "" {
+		show := strings.ToLower(f.Show)
+		if !strings.Contains(strings.ToLower(rec.Show), show) && !strings.Contains(strings.ToLower(rec.Input), show) {
+			return false
+		}
+	}
+	return true
+}
+
+// Записи, подходящие под фильтр, в порядке добавления
+func (h *History) Query(f HistoryFilter) []HistoryRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]HistoryRecord, 0)
+	for _, rec := range h.records {
+		if f.matches(rec) {
+			res = append(res, rec)
+		}
+	}
+	return res
+}
+
+// Сколько байт читать из начала, середины и конца файла для FileHash
+const hashChunkSize = 1 << 20
+
+// Быстрый хэш исходника: размер и по мегабайту из начала, середины и конца файла.
+// Читать многогигабайтные файлы целиком ради истории слишком долго, а переименованный
+// или перенесенный файл по такому хэшу все равно узнается
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Ошибка при чтении файла %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Ошибка при чтении файла %s: %w", path, err)
+	}
+
+	size := info.Size()
+	hash := sha256.New()
+	hash.Write([]byte(strconv.FormatInt(size, 10)))
+	buf := make([]byte, hashChunkSize)
+	for _, offset := range []int64{0, size/2 - hashChunkSize/2, size - hashChunkSize} {
+		if offset < 0 {
+			offset = 0
+		}
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("Ошибка при чтении файла %s: %w", path, err)
+		}
+		hash.Write(buf[:n])
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Начало периода для выборки: "7d", "36h" - столько времени назад от now,
+// "2026-10-01" - с начала этого дня по местному времени
+func ParseSince(str string, now time.Time) (time.Time, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", str, now.Location()); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(str, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid period %q", str)
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid period %q, expected 7d, 36h or 2006-01-02", str)
+	}
+	return now.Add(-d), nil
+}
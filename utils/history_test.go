@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "history.jsonl")
+	h, err := OpenHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	records := []HistoryRecord{
+		{Time: now.AddDate(0, 0, -10), Input: "/tv/Yellowstone S03E01.mkv", Hash: "a", Show: "Yellowstone", Profile: "hevc720", Status: "failed", Error: "ffmpeg failed"},
+		{Time: now.AddDate(0, 0, -9), Input: "/tv/Yellowstone S03E01.mkv", Hash: "a", Show: "Yellowstone", Profile: "hevc720", Status: HistoryDone, Output: "/out/Yellowstone S03E01.720p.H265.mkv"},
+		{Time: now.AddDate(0, 0, -2), Input: "/tv/Anime/Frieren - 12.mkv", Hash: "b", Show: "Frieren", Profile: "avc720-anime", Status: "failed", Error: "no audio"},
+		{Time: now.AddDate(0, 0, -1), Input: "/tv/Anime/Frieren - 13.mkv", Hash: "c", Show: "Frieren", Profile: "avc720-anime", Status: HistoryDone},
+	}
+	for _, rec := range records {
+		if err := h.Add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// недописанная запись упавшего запуска не мешает читать историю
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-10-18T12:00:00Z","input":"/tv/`)
+	f.Close()
+
+	h, err = OpenHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !h.IsOutput(records[1].Output) || h.IsOutput(records[1].Input) {
+		t.Errorf("Готовым результатом должен считаться только %s", records[1].Output)
+	}
+	if rec, ok := h.Converted("a", "hevc720"); !ok || rec.Output != records[1].Output {
+		t.Errorf("Ожидалась запись %+v, получено %+v (%v)", records[1], rec, ok)
+	}
+	if _, ok := h.Converted("a", "avc720-anime"); ok {
+		t.Errorf("Конвертация в другом профиле не должна находиться")
+	}
+	if _, ok := h.Converted("b", "avc720-anime"); ok {
+		t.Errorf("Неудачная конвертация не должна находиться")
+	}
+
+	tests := []struct {
+		filter   HistoryFilter
+		expected []int
+	}{
+		{HistoryFilter{}, []int{0, 1, 2, 3}},
+		{HistoryFilter{Status: "failed"}, []int{0, 2}},
+		{HistoryFilter{Status: "failed", Since: now.AddDate(0, 0, -7)}, []int{2}},
+		{HistoryFilter{Show: "frieren"}, []int{2, 3}},
+		{HistoryFilter{Show: "anime", Status: HistoryDone}, []int{3}},
+	}
+	for _, test := range tests {
+		actual := h.Query(test.filter)
+		if len(actual) != len(test.expected) {
+			t.Errorf("%+v: ожидалось %d записей, получено %d", test.filter, len(test.expected), len(actual))
+			continue
+		}
+		for i, n := range test.expected {
+			if actual[i].Input != records[n].Input || actual[i].Status != records[n].Status {
+				t.Errorf("%+v: запись %d: ожидалось %+v, получено %+v", test.filter, i, records[n], actual[i])
+			}
+		}
+	}
+}
+
+func TestOpenHistoryInvalid(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	// битая строка в середине: запись оборвалась, а следующий запуск дописал свою
+	writeTestFile(t, path, "{\"input\":\"a.mkv\",\"status\":\"done\"}\n{\"input\":\"b.mk\n{\"input\":\"c.mkv\",\"status\":\"failed\"}\n")
+	h, err := OpenHistory(path)
+	if err != nil {
+		t.Fatalf("Битая строка не должна мешать читать историю: %v", err)
+	}
+	records := h.Query(HistoryFilter{})
+	if len(records) != 2 || records[0].Input != "a.mkv" || records[1].Input != "c.mkv" {
+		t.Errorf("Ожидались записи a.mkv и c.mkv, получено %+v", records)
+	}
+	var lineErr *HistoryLineError
+	if skipped := h.Skipped(); len(skipped) != 1 || !errors.As(skipped[0], &lineErr) || lineErr.Line != 2 {
+		t.Errorf("Ожидалась пропущенная строка 2, получено %v", skipped)
+	}
+
+	// ошибка чтения файла по-прежнему ошибка
+	if _, err := OpenHistory(dir); err == nil {
+		t.Errorf("Ожидалась ошибка при чтении каталога")
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 3*hashChunkSize+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	writeTestFile(t, filepath.Join(dir, "a.mkv"), string(data))
+	writeTestFile(t, filepath.Join(dir, "renamed", "Show S01E01.mkv"), string(data))
+	data[len(data)/2] ^= 0xff
+	writeTestFile(t, filepath.Join(dir, "changed.mkv"), string(data))
+	writeTestFile(t, filepath.Join(dir, "small.mkv"), "small")
+
+	hash := func(name string) string {
+		h, err := FileHash(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	if hash("a.mkv") != hash("renamed/Show S01E01.mkv") {
+		t.Errorf("Хэш одинаковых файлов должен совпадать")
+	}
+	if hash("a.mkv") == hash("changed.mkv") {
+		t.Errorf("Хэш должен меняться при изменении середины файла")
+	}
+	if hash("small.mkv") == "" {
+		t.Errorf("Пустой хэш маленького файла")
+	}
+	if _, err := FileHash(filepath.Join(dir, "missing.mkv")); err == nil {
+		t.Errorf("Ожидалась ошибка для отсутствующего файла")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		str      string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"7d", time.Date(2026, 10, 11, 12, 0, 0, 0, time.UTC)},
+		{"36h", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		actual, err := ParseSince(test.str, now)
+		if err != nil {
+			t.Errorf("%q: неожиданная ошибка: %v", test.str, err)
+			continue
+		}
+		if !actual.Equal(test.expected) {
+			t.Errorf("%q: ожидалось %v, получено %v", test.str, test.expected, actual)
+		}
+	}
+	for _, str := range []string{"week", "-7d", "-1h", "2026-13-01"} {
+		if _, err := ParseSince(str, now); err == nil {
+			t.Errorf("%q: ожидалась ошибка", str)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Раскладка библиотеки Jellyfin/Plex
+const LibraryLayout = "{show}/Season {season:02}/{show} - S{season:02}E{episode:02} - {title}.{ext}"
+
+// Поля шаблона: {show}, {season:02}. Ширина с нулями допустима только у чисел
+var layoutField = regexp.MustCompile(`\{([a-z]+)(?::(0\d+))?\}`)
+
+// Числовые и строковые поля шаблона
+var (
+	layoutIntFields    = map[string]bool{"season": true, "episode": true, "absolute": true, "height": true, "year": true}
+	layoutStringFields = map[string]bool{"show": true, "title": true, "ext": true, "codec": true, "name": true, "resolution": true, "date": true}
+)
+
+// Шаблон пути выходного файла относительно каталога результатов
+type Layout struct {
+	template string
+}
+
+// Проверяем шаблон: все поля должны быть известны. "library" - раскладка LibraryLayout
+func ParseLayout(template string) (*Layout, error) {
+	if template == "library" {
+		template = LibraryLayout
+	}
+	if strings.TrimSpace(template) == "" {
+		return nil, fmt.Errorf("пустой шаблон пути")
+	}
+	if filepath.IsAbs(template) {
+		return nil, fmt.Errorf("шаблон пути %q должен быть относительным, каталог задается через -output-dir", template)
+	}
+	for _, m := range layoutField.FindAllStringSubmatch(template, -1) {
+		name, width := m[1], m[2]
+		switch {
+		case layoutIntFields[name]:
+		case layoutStringFields[name]:
+			if width != "" {
+				return nil, fmt.Errorf("шаблон пути: у поля {%s} не может быть ширины", name)
+			}
+		default:
+			return nil, fmt.Errorf("шаблон пути: неизвестное поле {%s}", name)
+		}
+	}
+	return &Layout{template: template}, nil
+}
+
+func (l *Layout) String() string {
+	return l.template
+}
+
+// Значения полей шаблона для одного файла
+type LayoutFields struct {
+	EpisodeName
+	Ext    string // расширение без точки
+	Height int    // высота кадра на выходе
+	Codec  string // H265, AV1
+	Name   string // имя по умолчанию без расширения: "Yellowstone S03E01.720p.H265"
+}
+
+// Заполняем шаблон. Пустые строковые поля убираются вместе с соседними разделителями:
+// "{show} - {title}" без названия серии дает "{show}"
+func (l *Layout) Render(file string, f LayoutFields) (string, error) {
+	var missing string
+	res := layoutField.ReplaceAllStringFunc(l.template, func(field string) string {
+		m := layoutField.FindStringSubmatch(field)
+		name, width := m[1], m[2]
+
+		if layoutIntFields[name] {
+			n := map[string]int{"season": f.Season, "episode": f.Episode, "absolute": f.Absolute, "height": f.Height, "year": f.Year}[name]
+			if n < 0 || (n == 0 && (name == "height" || name == "year")) {
+				if missing == "" {
+					missing = name
+				}
+				return ""
+			}
+			if width != "" {
+				w, _ := strconv.Atoi(width)
+				return fmt.Sprintf("%0*d", w, n)
+			}
+			return strconv.Itoa(n)
+		}
+
+		value := map[string]string{
+			"show":       f.Show,
+			"title":      f.Title,
+			"ext":        f.Ext,
+			"codec":      f.Codec,
+			"name":       f.Name,
+			"resolution": f.Resolution,
+			"date":       f.Date,
+		}[name]
+		if name == "show" && value == "" && missing == "" {
+			// без названия сериала не получится каталог сериала
+			missing = name
+		}
+		return sanitizeName(value)
+	})
+	if missing != "" {
+		return "", &LayoutError{File: file, Field: missing}
+	}
+
+	segments := strings.Split(filepath.ToSlash(res), "/")
+	for i, segment := range segments {
+		segments[i] = cleanSegment(segment)
+		if segments[i] == "" || segments[i] == "." || segments[i] == ".." {
+			return "", fmt.Errorf("output layout for %s gives invalid path %q", file, res)
+		}
+	}
+	res = filepath.Join(segments...)
+	if f.Ext != "" && !strings.HasSuffix(res, "."+f.Ext) {
+		res += "." + f.Ext
+	}
+	return res, nil
+}
+
+// Символы, недопустимые в именах файлов на Windows и в Samba
+var unsafeChars = strings.NewReplacer("/", "-", "\\", "-", ":", " -", "*", "", "?", "", "\"", "'", "<", "", ">", "", "|", "-")
+
+func sanitizeName(str string) string {
+	return strings.TrimSpace(unsafeChars.Replace(str))
+}
+
+var (
+	// разделитель, за которым ничего не осталось: "Show - .mkv", "Show - "
+	danglingSeparator = regexp.MustCompile(`\s+[-–]\s*(\.[^.\s]+$|$)`)
+	// разделитель в начале: " - Title"
+	leadingSeparator = regexp.MustCompile(`^\s*[-–]\s+`)
+	emptyBrackets    = regexp.MustCompile(`\(\s*\)|\[\s*\]`)
+	spaces           = regexp.MustCompile(`\s{2,}`)
+)
+
+// Убираем следы пустых полей в одной части пути
+func cleanSegment(segment string) string {
+	segment = emptyBrackets.ReplaceAllString(segment, "")
+	segment = danglingSeparator.ReplaceAllString(segment, "$1")
+	segment = leadingSeparator.ReplaceAllString(segment, "")
+	segment = spaces.ReplaceAllString(segment, " ")
+	return strings.TrimSpace(segment)
+}
+
+// Куда и под каким именем писать результат
+type OutputOptions struct {
+	Dir    string  // каталог результатов, пустой - рядом с исходником
+	Layout *Layout // шаблон пути, nil - прежнее имя файла с суффиксом профиля
+}
+
+// Путь выходного файла для исходника и высоты кадра на выходе
+func OutputPath(inputFile string, opts OutputOptions, profile Profile, height int) (string, error) {
+	if height <= 0 {
+		height = profile.Height
+	}
+	return layoutPath(inputFile, opts, OutputExt(inputFile), profile.Suffix(height), LayoutFields{
+		Height: height,
+		Codec:  codecLabels[profile.Codec],
+	})
+}
+
+// Новый путь исходника без конвертации, для переименования: расширение остается прежним,
+// {height} и {codec} в шаблоне не заполнить
+func RenamePath(inputFile string, opts OutputOptions) (string, error) {
+	return layoutPath(inputFile, opts, filepath.Ext(inputFile), "", LayoutFields{})
+}
+
+// Путь по шаблону opts.Layout, без шаблона - прежнее имя с суффиксом suffix.
+// В fields заполняются поля разбора имени, Ext и Name
+func layoutPath(inputFile string, opts OutputOptions, ext string, suffix string, fields LayoutFields) (string, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Dir(inputFile)
+	}
+
+	// прежнее имя: "Yellowstone S03E01.720p.H265.mkv". Без шаблона оно не меняется,
+	// иначе готовые файлы прошлых запусков не найдутся на своих местах
+	name := SplitFileNameByPattern(filepath.Base(inputFile), suffix)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if opts.Layout == nil {
+		return filepath.Join(dir, name+ext), nil
+	}
+
+	episode, err := ParseEpisodeName(inputFile)
+	if err != nil {
+		return "", err
+	}
+	fields.EpisodeName = episode
+	fields.Ext = strings.TrimPrefix(ext, ".")
+	fields.Name = name
+	rel, err := opts.Layout.Render(inputFile, fields)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, rel), nil
+}
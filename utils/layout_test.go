@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	if _, err := ParseLayout(LibraryLayout); err != nil {
+		t.Errorf("Неожиданная ошибка для %s: %v", LibraryLayout, err)
+	}
+	for _, template := range []string{"", "{show}/{group}.{ext}", "{title:02}.{ext}", "/media/{show}.{ext}"} {
+		if _, err := ParseLayout(template); err == nil {
+			t.Errorf("%q: ожидалась ошибка", template)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	library, err := ParseLayout(LibraryLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quality, err := ParseLayout("{show}/{show} S{season:02}E{episode:02} [{height}p {codec}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anime, err := ParseLayout("{show}/{show} - {absolute:03}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     OutputOptions
+		height   int
+		expected string
+	}{
+		{
+			name:     "рядом с исходником",
+			input:    "tv/Yellowstone S03E01 WEB-DL 2160p.mkv",
+			expected: "tv/Yellowstone S03E01.720p.H265.mkv",
+		},
+		{
+			name:     "имя не разобрать",
+			input:    "tv/Pilot.mkv",
+			expected: "tv/Pilot.720p.H265.mkv",
+		},
+		// без шаблона имена те же, что и до шаблонов: по ним находятся готовые файлы
+		{
+			name:     "номер серии и название",
+			input:    "tv/01x00 Pilot [CBS Drama+OPT+Eng].mkv",
+			expected: "tv/S01E00.Pilot.720p.H265.mkv",
+		},
+		{
+			name:     "номер серии в начале",
+			input:    "tv/Friends/Season 01/01. The One Where Monica Gets a Roommate.mkv",
+			expected: "tv/Friends/Season 01/E01.The One Where Monica Gets a Roommate.720p.H265.mkv",
+		},
+		{
+			name:     "название серии после номера",
+			input:    "tv/The.Expanse.S02E05.Home.1080p.WEB-DL.mkv",
+			expected: "tv/The.Expanse.S02E05.720p.H265.mkv",
+		},
+		{
+			name:     "в каталог результатов",
+			input:    "tv/Yellowstone S03E01 WEB-DL 2160p.avi",
+			opts:     OutputOptions{Dir: "out"},
+			expected: "out/Yellowstone S03E01.720p.H265.mkv",
+		},
+		{
+			name:     "библиотека",
+			input:    "tv/The.Expanse.S02E05.Home.1080p.WEB-DL.mkv",
+			opts:     OutputOptions{Dir: "/media/tv", Layout: library},
+			expected: "/media/tv/The Expanse/Season 02/The Expanse - S02E05 - Home.mkv",
+		},
+		{
+			name:     "библиотека без названия серии",
+			input:    "tv/Yellowstone S03E01 WEB-DL 2160p.mp4",
+			opts:     OutputOptions{Dir: "lib", Layout: library},
+			expected: "lib/Yellowstone/Season 03/Yellowstone - S03E01.mp4",
+		},
+		{
+			name:     "сезон из каталога",
+			input:    "Friends/Season 01/01. The One: Part 1?.mkv",
+			opts:     OutputOptions{Dir: "lib", Layout: library},
+			expected: "lib/Friends/Season 01/Friends - S01E01 - The One - Part 1.mkv",
+		},
+		{
+			name:     "высота и кодек",
+			input:    "Yellowstone S03E01 WEB-DL 2160p.mkv",
+			opts:     OutputOptions{Dir: "lib", Layout: quality},
+			height:   540,
+			expected: "lib/Yellowstone/Yellowstone S03E01 [540p H265].mkv",
+		},
+		{
+			name:     "сквозной номер",
+			input:    "[SubsPlease] Jujutsu Kaisen - 47 [1080p].mkv",
+			opts:     OutputOptions{Dir: "anime", Layout: anime},
+			expected: "anime/Jujutsu Kaisen/Jujutsu Kaisen - 047.mkv",
+		},
+	}
+	for _, test := range tests {
+		actual, err := OutputPath(filepath.FromSlash(test.input), test.opts, DefaultProfile(), test.height)
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.name, err)
+			continue
+		}
+		if actual != filepath.FromSlash(test.expected) {
+			t.Errorf("%s: ожидалось %q, получено %q", test.name, test.expected, actual)
+		}
+	}
+
+	// без сезона и названия сериала раскладку не заполнить
+	var layoutErr *LayoutError
+	_, err = OutputPath("01. The One Where Monica Gets a Roommate.mkv", OutputOptions{Layout: library}, DefaultProfile(), 0)
+	if !errors.As(err, &layoutErr) || layoutErr.Field != "show" {
+		t.Errorf("Ожидалась LayoutError для {show}, получено %v", err)
+	}
+}
+
+func TestRenamePath(t *testing.T) {
+	library, err := ParseLayout(LibraryLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		opts     OutputOptions
+		expected string
+	}{
+		{"tv/Yellowstone S03E01 WEB-DL 2160p.avi", OutputOptions{}, "tv/Yellowstone S03E01.avi"},
+		{"tv/The.Expanse.S02E05.Home.1080p.WEB-DL.mp4", OutputOptions{Dir: "lib", Layout: library}, "lib/The Expanse/Season 02/The Expanse - S02E05 - Home.mp4"},
+		{"tv/Pilot.mkv", OutputOptions{}, "tv/Pilot.mkv"},
+		{"tv/Friends/Season 01/01. The One Where Monica Gets a Roommate.mkv", OutputOptions{}, "tv/Friends/Season 01/E01.The One Where Monica Gets a Roommate.mkv"},
+		{"tv/01x00 Pilot [CBS Drama+OPT+Eng].mkv", OutputOptions{}, "tv/S01E00.Pilot.mkv"},
+	}
+	for _, test := range tests {
+		actual, err := RenamePath(filepath.FromSlash(test.input), test.opts)
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка: %v", test.input, err)
+			continue
+		}
+		if actual != filepath.FromSlash(test.expected) {
+			t.Errorf("%s: ожидалось %q, получено %q", test.input, test.expected, actual)
+		}
+	}
+}
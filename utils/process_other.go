@@ -0,0 +1,8 @@
+//go:build !unix
+
+package utils
+
+import "os/exec"
+
+// Вне unix групп процессов нет, дочерние процессы запускаются как есть
+func ownProcessGroup(cmd *exec.Cmd) {}
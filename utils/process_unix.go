@@ -0,0 +1,16 @@
+//go:build unix
+
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Запускаем ffmpeg и ffprobe в своей группе процессов. Ctrl+C в терминале получает
+// вся группа переднего плана, и без этого дочерние процессы умирали бы сразу,
+// хотя первый Ctrl+C должен дать идущим кодированиям закончиться.
+// Останавливаем их мы сами - отменой контекста
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
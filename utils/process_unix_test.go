@@ -0,0 +1,74 @@
+//go:build unix
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Ctrl+C в терминале посылает SIGINT всей группе переднего плана. Проверяем настоящим
+// сигналом: тест перезапускает себя в отдельной группе, как программу в терминале,
+// и шлет SIGINT этой группе, пока в ней работает ffmpeg. ffmpeg должен доработать
+func TestFfmpegSurvivesGroupInterrupt(t *testing.T) {
+	if os.Getenv("VC_SIGNAL_HELPER") != "" {
+		signalHelper()
+		return
+	}
+
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\ntouch '" + ready + "'\ni=0\nwhile [ $i -lt 10 ]; do echo \"frame=$i\"; echo progress=continue; sleep 0.1; i=$((i+1)); done\necho progress=end\n"
+	if err := os.WriteFile(fakeFfmpeg, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFfmpegSurvivesGroupInterrupt$")
+	cmd.Env = append(os.Environ(), "VC_SIGNAL_HELPER="+fakeFfmpeg)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			t.Fatalf("ffmpeg не запустился:\n%s", out.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("ffmpeg прерван сигналом группы: %v\n%s", err, out.String())
+	}
+}
+
+// Программа в терминале: первый SIGINT перехватывает сама, ffmpeg работает дальше
+func signalHelper() {
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	err := runFfmpeg(context.Background(), os.Getenv("VC_SIGNAL_HELPER"), nil, "input.mkv", Progress{}, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Профиль кодирования видео
+type Profile struct {
+	Name    string   `yaml:"-"`
+	Codec   string   `yaml:"codec"`   // libx264, libx265, libsvtav1, libvpx-vp9
+	CRF     *int     `yaml:"crf"`     // качество, nil - не задано; 0 - без потерь у libx264
+	Bitrate string   `yaml:"bitrate"` // целевой битрейт, например 2500k
+	Preset  string   `yaml:"preset"`
+	Tune    string   `yaml:"tune"`
+	Height  int      `yaml:"height"`  // целевая высота кадра, 0 - не менять
+	PixFmt  string   `yaml:"pix_fmt"` // например yuv420p10le
+	Filters []string `yaml:"filters"` // дополнительные фильтры после масштабирования
+	Threads int      `yaml:"threads"` // потоков на одно кодирование, 0 - подобрать по числу ядер
+
+	// видео в нужном кодеке и разрешении с битрейтом выше этого все равно перекодируется
+	MaxBitrate string `yaml:"max_bitrate"`
+}
+
+// Набор профилей из файла конфигурации
+type Profiles struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// Название кодека в имени файла
+var codecLabels = map[string]string{
+	"libx264":    "H264",
+	"libx265":    "H265",
+	"libsvtav1":  "AV1",
+	"libvpx-vp9": "VP9",
+}
+
+// Допустимые значения crf для каждого кодека
+var crfRanges = map[string][2]int{
+	"libx264":    {0, 51},
+	"libx265":    {0, 51},
+	"libsvtav1":  {0, 63},
+	"libvpx-vp9": {0, 63},
+}
+
+// Профиль по умолчанию: то, что программа делала всегда
+const DefaultProfileName = "hevc720"
+
+func DefaultProfile() Profile {
+	crf := 23
+	return Profile{
+		Name:   DefaultProfileName,
+		Codec:  "libx265",
+		CRF:    &crf,
+		Height: 720,
+	}
+}
+
+// Встроенные профили, доступные без файла конфигурации
+func DefaultProfiles() Profiles {
+	p := DefaultProfile()
+	return Profiles{
+		Default:  DefaultProfileName,
+		Profiles: map[string]*Profile{DefaultProfileName: &p},
+	}
+}
+
+// Загружаем профили из YAML файла. Если файла нет, возвращаем встроенные профили
+func LoadProfiles(path string) (Profiles, error) {
+	res := DefaultProfiles()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("Ошибка при чтении файла профилей %s: %w", path, err)
+	}
+
+	var loaded Profiles
+	if err := yaml.Unmarshal(data, &loaded); err != nil {
+		return res, fmt.Errorf("Ошибка при разборе файла профилей %s: %w", path, err)
+	}
+
+	// профили из файла дополняют и переопределяют встроенные
+	for name, p := range loaded.Profiles {
+		if p == nil {
+			return res, fmt.Errorf("Профиль %q в файле %s пустой", name, path)
+		}
+		p.Name = name
+		if err := p.Validate(); err != nil {
+			return res, fmt.Errorf("Ошибка в файле профилей %s: %w", path, err)
+		}
+		res.Profiles[name] = p
+	}
+	if loaded.Default != "" {
+		res.Default = loaded.Default
+	}
+
+	if _, ok := res.Profiles[res.Default]; !ok {
+		return res, fmt.Errorf("Профиль по умолчанию %q не найден в файле %s", res.Default, path)
+	}
+	return res, nil
+}
+
+// Возвращаем профиль по имени, пустое имя - профиль по умолчанию
+func (ps Profiles) Get(name string) (Profile, error) {
+	if name == "" {
+		name = ps.Default
+	}
+	p, ok := ps.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("Профиль %q не найден, доступные профили: %s", name, strings.Join(ps.Names(), ", "))
+	}
+	return *p, nil
+}
+
+// Имена профилей по алфавиту
+func (ps Profiles) Names() []string {
+	res := make([]string, 0, len(ps.Profiles))
+	for name := range ps.Profiles {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Проверяем профиль на корректность
+func (p Profile) Validate() error {
+	if _, ok := codecLabels[p.Codec]; !ok {
+		return fmt.Errorf("профиль %q: неизвестный кодек %q", p.Name, p.Codec)
+	}
+	if p.CRF != nil && p.Bitrate != "" {
+		return fmt.Errorf("профиль %q: нужно указать либо crf, либо bitrate", p.Name)
+	}
+	if p.CRF == nil && p.Bitrate == "" {
+		return fmt.Errorf("профиль %q: не указан ни crf, ни bitrate", p.Name)
+	}
+	if r := crfRanges[p.Codec]; p.CRF != nil && (*p.CRF < r[0] || *p.CRF > r[1]) {
+		return fmt.Errorf("профиль %q: crf %d вне диапазона %d-%d для %s", p.Name, *p.CRF, r[0], r[1], p.Codec)
+	}
+	if p.Height < 0 {
+		return fmt.Errorf("профиль %q: отрицательная высота %d", p.Name, p.Height)
+	}
+	if p.Threads < 0 {
+		return fmt.Errorf("профиль %q: отрицательное число потоков %d", p.Name, p.Threads)
+	}
+	for _, b := range []string{p.Bitrate, p.MaxBitrate} {
+		if _, err := parseBitrate(b); err != nil {
+			return fmt.Errorf("профиль %q: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// Суффикс для имени выходного файла по реальной высоте кадра: ".720p.H265".
+// Если высота неизвестна, берется высота из профиля
+func (p Profile) Suffix(height int) string {
+	if height <= 0 {
+		height = p.Height
+	}
+	if height > 0 {
+		return "." + strconv.Itoa(height) + "p." + codecLabels[p.Codec]
+	}
+	return "." + codecLabels[p.Codec]
+}
+
+// Настраиваем выходной видеопоток для кодирования по профилю
+func (p Profile) configureVideo(s *OutputStream, scale Scale) {
+	s.Codec = p.Codec
+
+	if p.Bitrate != "" {
+		s.Set("b:v", p.Bitrate)
+	} else if p.CRF != nil {
+		s.Set("crf", strconv.Itoa(*p.CRF))
+		// без -b:v 0 libvpx-vp9 использует crf только как ограничение
+		if p.Codec == "libvpx-vp9" {
+			s.Set("b:v", "0")
+		}
+	}
+
+	if p.Preset != "" {
+		// у libvpx-vp9 нет -preset, скорость задается через -cpu-used
+		if p.Codec == "libvpx-vp9" {
+			s.Set("cpu-used", p.Preset)
+		} else {
+			s.Set("preset", p.Preset)
+		}
+	}
+	if p.Tune != "" {
+		s.Set("tune", p.Tune)
+	}
+	if p.PixFmt != "" {
+		s.Set("pix_fmt", p.PixFmt)
+	}
+	p.configureThreads(s)
+
+	if scale.Filter != "" {
+		s.Filter(scale.Filter)
+	}
+	s.Filter(p.Filters...)
+}
+
+// Ограничиваем число потоков кодировщика, чтобы одновременные ffmpeg не делили все ядра между собой
+func (p Profile) configureThreads(s *OutputStream) {
+	if p.Threads <= 0 {
+		return
+	}
+	threads := strconv.Itoa(p.Threads)
+
+	switch p.Codec {
+	case "libx265":
+		// x265 не смотрит на -threads, размер пула задается через свои параметры
+		s.Set("x265-params", "pools="+threads+":frame-threads="+strconv.Itoa(x265FrameThreads(p.Threads)))
+	case "libvpx-vp9":
+		// без row-mt libvpx-vp9 почти не использует больше одного потока на 720p
+		s.Set("threads", threads).Set("row-mt", "1")
+	default:
+		s.Set("threads", threads)
+	}
+}
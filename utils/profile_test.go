@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	// пример из репозитория должен всегда загружаться
+	profiles, err := LoadProfiles("../profiles.example.yaml")
+	if err != nil {
+		t.Fatalf("Ошибка при загрузке примера профилей: %s", err)
+	}
+	expectedNames := []string{"av1-1080", "avc720-anime", "hevc1080-10bit", "hevc720", "vp9-720"}
+	if names := profiles.Names(); !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Ожидались профили %v, получено %v", expectedNames, names)
+	}
+
+	p, err := profiles.Get("")
+	if err != nil || p.Name != DefaultProfileName {
+		t.Errorf("Ожидался профиль по умолчанию %s, получено %q (%v)", DefaultProfileName, p.Name, err)
+	}
+	if _, err := profiles.Get("h266"); err == nil {
+		t.Error("Ожидалась ошибка для несуществующего профиля")
+	}
+
+	// без файла доступны только встроенные профили
+	profiles, err = LoadProfiles(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Ошибка при загрузке встроенных профилей: %s", err)
+	}
+	if p, _ := profiles.Get(""); !reflect.DeepEqual(p, DefaultProfile()) {
+		t.Errorf("Ожидался встроенный профиль %+v, получено %+v", DefaultProfile(), p)
+	}
+}
+
+func TestLoadProfilesInvalid(t *testing.T) {
+	tests := map[string]string{
+		"неизвестный кодек":    "profiles:\n  x:\n    codec: mpeg2video\n    crf: 20\n",
+		"crf и bitrate":        "profiles:\n  x:\n    codec: libx264\n    crf: 20\n    bitrate: 2000k\n",
+		"нет crf и bitrate":    "profiles:\n  x:\n    codec: libx264\n",
+		"нет профиля default":  "default: x\nprofiles:\n  y:\n    codec: libx264\n    crf: 20\n",
+		"не YAML":              "profiles: [",
+		"отрицательные потоки": "profiles:\n  x:\n    codec: libx264\n    crf: 20\n    threads: -1\n",
+		"crf 0 и bitrate":      "profiles:\n  x:\n    codec: libx264\n    crf: 0\n    bitrate: 2000k\n",
+		"crf x264 больше 51":   "profiles:\n  x:\n    codec: libx264\n    crf: 52\n",
+		"crf x265 больше 51":   "profiles:\n  x:\n    codec: libx265\n    crf: 63\n",
+		"crf av1 больше 63":    "profiles:\n  x:\n    codec: libsvtav1\n    crf: 64\n",
+		"отрицательный crf":    "profiles:\n  x:\n    codec: libvpx-vp9\n    crf: -1\n",
+	}
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "profiles.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Ошибка при создании временного файла: %s", err)
+		}
+		if _, err := LoadProfiles(path); err == nil {
+			t.Errorf("%s: ожидалась ошибка", name)
+		}
+	}
+}
+
+func TestLoadProfilesCRF(t *testing.T) {
+	tests := []struct {
+		content string
+		crf     int
+	}{
+		{"profiles:\n  lossless:\n    codec: libx264\n    crf: 0\n", 0},
+		{"profiles:\n  lossless:\n    codec: libx265\n    crf: 51\n", 51},
+		{"profiles:\n  lossless:\n    codec: libsvtav1\n    crf: 63\n", 63},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "profiles.yaml")
+		if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatalf("Ошибка при создании временного файла: %s", err)
+		}
+		profiles, err := LoadProfiles(path)
+		if err != nil {
+			t.Errorf("%q: неожиданная ошибка: %v", test.content, err)
+			continue
+		}
+		if p, _ := profiles.Get("lossless"); p.CRF == nil || *p.CRF != test.crf {
+			t.Errorf("%q: ожидался crf %d, получено %v", test.content, test.crf, p.CRF)
+		}
+	}
+}
+
+func TestProfileConfigureVideo(t *testing.T) {
+	tests := []struct {
+		profile        Profile
+		expectedArgs   []string
+		expectedSuffix string
+	}{
+		{
+			profile:        DefaultProfile(),
+			expectedArgs:   []string{"-c:v", "libx265", "-crf", "23", "-vf", "scale=-2:720"},
+			expectedSuffix: ".720p.H265",
+		},
+		{
+			profile:        Profile{Codec: "libx264", CRF: intPtr(20), Preset: "slow", Tune: "animation", Height: 1080, PixFmt: "yuv420p"},
+			expectedArgs:   []string{"-c:v", "libx264", "-crf", "20", "-preset", "slow", "-tune", "animation", "-pix_fmt", "yuv420p", "-vf", "scale=-2:1080"},
+			expectedSuffix: ".1080p.H264",
+		},
+		{
+			profile:        Profile{Codec: "libvpx-vp9", CRF: intPtr(31), Preset: "2", Filters: []string{"hqdn3d"}},
+			expectedArgs:   []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-cpu-used", "2", "-vf", "hqdn3d"},
+			expectedSuffix: ".VP9",
+		},
+		{
+			profile:        Profile{Codec: "libx264", CRF: intPtr(0), PixFmt: "yuv444p"},
+			expectedArgs:   []string{"-c:v", "libx264", "-crf", "0", "-pix_fmt", "yuv444p"},
+			expectedSuffix: ".H264",
+		},
+		{
+			profile:        Profile{Codec: "libsvtav1", Bitrate: "2500k", Height: 720, Filters: []string{"hqdn3d", "unsharp"}},
+			expectedArgs:   []string{"-c:v", "libsvtav1", "-b:v", "2500k", "-vf", "scale=-2:720,hqdn3d,unsharp"},
+			expectedSuffix: ".720p.AV1",
+		},
+	}
+
+	for _, test := range tests {
+		cmd := &Command{}
+		video := cmd.AddStream(StreamRef{Type: StreamVideo}, "copy")
+		test.profile.configureVideo(video, ScaleFor(VideoInfo{}, test.profile.Height))
+		// отрезаем -map 0:v:0 и пустое имя выходного файла
+		args := cmd.Args()
+		if args = args[:len(args)-3]; !reflect.DeepEqual(args, test.expectedArgs) {
+			t.Errorf("Ожидалось %v, получено %v", test.expectedArgs, args)
+		}
+		if suffix := test.profile.Suffix(0); suffix != test.expectedSuffix {
+			t.Errorf("Ожидался суффикс %q, получено %q", test.expectedSuffix, suffix)
+		}
+	}
+}
+
+func TestProfileSuffixRealHeight(t *testing.T) {
+	if suffix := DefaultProfile().Suffix(480); suffix != ".480p.H265" {
+		t.Errorf("Ожидался суффикс .480p.H265, получено %q", suffix)
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
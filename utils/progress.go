@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Состояние кодирования одного файла по данным ffmpeg -progress
+type Progress struct {
+	File     string
+	Frame    int64
+	FPS      float64
+	OutTime  time.Duration // сколько уже закодировано
+	Speed    float64       // во сколько раз быстрее реального времени
+	Bitrate  string        // как его пишет ffmpeg: 1523.4kbits/s
+	Duration time.Duration // длительность исходника, 0 - неизвестна
+	Done     bool
+}
+
+// Процент выполнения или -1, если длительность неизвестна
+func (p Progress) Percent() float64 {
+	if p.Duration <= 0 {
+		return -1
+	}
+	if p.Done {
+		return 100
+	}
+	percent := float64(p.OutTime) / float64(p.Duration) * 100
+	if percent > 100 {
+		return 100
+	}
+	if percent < 0 {
+		return 0
+	}
+	return percent
+}
+
+// Сколько осталось до конца или -1, если оценить нельзя
+func (p Progress) ETA() time.Duration {
+	if p.Duration <= 0 || p.Speed <= 0 {
+		return -1
+	}
+	left := p.Duration - p.OutTime
+	if left < 0 {
+		return 0
+	}
+	return time.Duration(float64(left) / p.Speed)
+}
+
+// Строка для вывода: "file.mkv  42.1% frame=1234 fps=55.2 speed=2.10x bitrate=1500.0kbits/s ETA 00:12:34"
+func (p Progress) String() string {
+	var b strings.Builder
+	b.WriteString(p.File)
+	if percent := p.Percent(); percent >= 0 {
+		fmt.Fprintf(&b, " %5.1f%%", percent)
+	} else {
+		fmt.Fprintf(&b, " %s", formatClock(p.OutTime))
+	}
+	fmt.Fprintf(&b, " frame=%d fps=%.1f speed=%.2fx", p.Frame, p.FPS, p.Speed)
+	if p.Bitrate != "" {
+		fmt.Fprintf(&b, " bitrate=%s", p.Bitrate)
+	}
+	if eta := p.ETA(); eta >= 0 && !p.Done {
+		fmt.Fprintf(&b, " ETA %s", formatClock(eta))
+	}
+	if p.Done {
+		b.WriteString(" done")
+	}
+	return b.String()
+}
+
+// 1h2m3s => "01:02:03"
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// Читаем вывод ffmpeg -progress: блоки key=value, каждый заканчивается строкой progress=continue|end.
+// После каждого блока вызывается fn
+func parseProgress(r io.Reader, p Progress, fn func(Progress)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			p.Frame = parseInt64(value)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			if value != "N/A" {
+				p.Bitrate = value
+			}
+		case "out_time_us", "out_time_ms":
+			// out_time_ms на самом деле тоже в микросекундах
+			if us := parseInt64(value); us > 0 {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p.Done = value == "end"
+			if fn != nil {
+				fn(p)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Хранит только последние max байт записанного: для хвоста вывода ffmpeg
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte(nil), t.buf...)
+}
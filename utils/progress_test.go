@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgress(t *testing.T) {
+	f, err := os.Open("testdata/progress.txt")
+	if err != nil {
+		t.Fatalf("Ошибка при чтении тестового файла: %s", err)
+	}
+	defer f.Close()
+
+	updates := make([]Progress, 0)
+	err = parseProgress(f, Progress{File: "a.mkv", Duration: 4 * time.Minute}, func(p Progress) {
+		updates = append(updates, p)
+	})
+	if err != nil {
+		t.Fatalf("Ошибка при разборе прогресса: %s", err)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("Ожидалось 3 обновления, получено %d", len(updates))
+	}
+
+	first := updates[0]
+	if first.Frame != 120 || first.OutTime != 4800*time.Millisecond || first.Bitrate != "" || first.Done {
+		t.Errorf("Неверно разобран первый блок: %+v", first)
+	}
+
+	p := updates[1]
+	if p.Frame != 1500 || p.FPS != 48.5 || p.Speed != 2 || p.Bitrate != "1523.4kbits/s" || p.OutTime != time.Minute {
+		t.Errorf("Неверно разобран второй блок: %+v", p)
+	}
+	if p.Percent() != 25 {
+		t.Errorf("Ожидалось 25%%, получено %f", p.Percent())
+	}
+	if p.ETA() != 90*time.Second {
+		t.Errorf("Ожидалось ETA 1m30s, получено %s", p.ETA())
+	}
+	expected := "a.mkv  25.0% frame=1500 fps=48.5 speed=2.00x bitrate=1523.4kbits/s ETA 00:01:30"
+	if p.String() != expected {
+		t.Errorf("Ожидалось %q, получено %q", expected, p.String())
+	}
+
+	last := updates[2]
+	if !last.Done || last.Percent() != 100 || !strings.HasSuffix(last.String(), " done") {
+		t.Errorf("Последний блок должен быть завершающим: %+v", last)
+	}
+}
+
+func TestProgressUnknownDuration(t *testing.T) {
+	p := Progress{File: "a.mkv", OutTime: 61 * time.Second, Speed: 1.5}
+	if p.Percent() != -1 || p.ETA() != -1 {
+		t.Errorf("Без длительности процент и ETA неизвестны: %f %s", p.Percent(), p.ETA())
+	}
+	if expected := "a.mkv 00:01:01 frame=0 fps=0.0 speed=1.50x"; p.String() != expected {
+		t.Errorf("Ожидалось %q, получено %q", expected, p.String())
+	}
+}
+
+func TestDisplayPlain(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(&out, false)
+
+	d.Update(Progress{File: "a.mkv", Frame: 1})
+	d.Update(Progress{File: "a.mkv", Frame: 2}) // слишком рано, не выводится
+	d.Update(Progress{File: "b.mkv", Frame: 3})
+	d.Update(Progress{File: "a.mkv", Frame: 4, Done: true})
+	d.Remove("a.mkv")
+	d.Write([]byte("message\n"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "frame=1") || !strings.Contains(lines[1], "frame=3") ||
+		!strings.Contains(lines[2], "frame=4") || lines[3] != "message" {
+		t.Errorf("Неверный вывод без терминала:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Error("Без терминала не должно быть управляющих последовательностей")
+	}
+}
+
+func TestDisplayTerminal(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(&out, true)
+
+	d.Update(Progress{File: "a.mkv", Frame: 1})
+	d.Update(Progress{File: "b.mkv", Frame: 2})
+	out.Reset()
+
+	// сообщение: стираем две строки прогресса, пишем сообщение, рисуем прогресс заново
+	d.Write([]byte("message\n"))
+	expected := "\x1b[2A\x1b[J" + "message\n" +
+		"\x1b[2K" + (Progress{File: "a.mkv", Frame: 1}).String() + "\n" +
+		"\x1b[2K" + (Progress{File: "b.mkv", Frame: 2}).String() + "\n"
+	if out.String() != expected {
+		t.Errorf("Ожидалось %q, получено %q", expected, out.String())
+	}
+
+	out.Reset()
+	d.Remove("a.mkv")
+	expected = "\x1b[2A\x1b[J" + "\x1b[2K" + (Progress{File: "b.mkv", Frame: 2}).String() + "\n"
+	if out.String() != expected {
+		t.Errorf("Ожидалось %q, получено %q", expected, out.String())
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := newTailBuffer(8)
+	b.Write([]byte("0123456"))
+	b.Write([]byte("789"))
+	if string(b.Bytes()) != "23456789" {
+		t.Errorf("Ожидалось 23456789, получено %q", b.Bytes())
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Состояние выходного файла, оставшегося от прошлого запуска
+type OutputState int
+
+const (
+	OutputMissing    OutputState = iota // файла нет
+	OutputValid                         // файл полный, исходник можно пропустить
+	OutputIncomplete                    // файл недописан или испорчен, его нужно переделать
+)
+
+func (s OutputState) String() string {
+	switch s {
+	case OutputValid:
+		return "valid"
+	case OutputIncomplete:
+		return "incomplete"
+	default:
+		return "missing"
+	}
+}
+
+// Имена наших выходных файлов заканчиваются суффиксом профиля: ".720p.H265.mkv", ".VP9.mkv"
+var outputNamePattern = regexp.MustCompile(`\.(\d+p\.)?(` + strings.Join(codecLabelNames(), "|") + `)\.[^.]+$`)
+
+func codecLabelNames() []string {
+	res := make([]string, 0, len(codecLabels))
+	for _, label := range codecLabels {
+		res = append(res, regexp.QuoteMeta(label))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Проверяем, что файл - результат нашей конвертации или его временный файл, а не исходник
+func IsOutputName(name string) bool {
+	if strings.HasSuffix(name, partialSuffix) {
+		return true
+	}
+	return outputNamePattern.MatchString(filepath.Base(name))
+}
+
+// Проверяем готовый файл: длительность, высота кадра и дорожки должны совпадать с ожидаемыми.
+// Ошибка возвращается, только если проверку нельзя выполнить (нет ffprobe, отмена),
+// испорченный файл - это OutputIncomplete с причиной
+func CheckOutput(ctx context.Context, outputFile string, want Expectation) (OutputState, string, error) {
+	if _, err := os.Stat(outputFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return OutputMissing, "", nil
+		}
+		return OutputMissing, "", fmt.Errorf("Ошибка при проверке файла %s: %w", outputFile, err)
+	}
+
+	info, err := GetStreamsInfo(ctx, outputFile)
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, ErrToolNotFound) {
+			return OutputMissing, "", err
+		}
+		return OutputIncomplete, "can't probe: " + err.Error(), nil
+	}
+	if reason := want.mismatch(info); reason != "" {
+		return OutputIncomplete, reason, nil
+	}
+	return OutputValid, "", nil
+}
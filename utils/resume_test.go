@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsOutputName(t *testing.T) {
+	tests := map[string]bool{
+		"Yellowstone S03E01.720p.H265.mkv":                 true,
+		"S01E00.Pilot.1080p.H264.mkv":                      true,
+		"E01.The One Where Monica Gets a Roommate.VP9.mkv": true,
+		"dir/S01E01.2160p.AV1.mkv":                         true,
+		"Yellowstone S03E01 WEB-DL 2160p.mkv":              false,
+		"01x00 Pilot [CBS Drama+OPT+Eng].mkv":              false,
+		"Show.S01E01.1080p.WEB.H265-GROUP.mkv":             false,
+		"Show.S01E01.720p.x265.mkv":                        false,
+	}
+	for name, expected := range tests {
+		if actual := IsOutputName(name); actual != expected {
+			t.Errorf("%s: ожидалось %v, получено %v", name, expected, actual)
+		}
+	}
+}
+
+func TestCheckOutputMissing(t *testing.T) {
+	state, _, err := CheckOutput(context.Background(), filepath.Join(t.TempDir(), "missing.mkv"), Expectation{Duration: time.Hour})
+	if err != nil || state != OutputMissing {
+		t.Errorf("Ожидалось %s без ошибки, получено %s (%v)", OutputMissing, state, err)
+	}
+}
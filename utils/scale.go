@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Решение о размере кадра на выходе
+type Scale struct {
+	Width  int    // ширина кадра на выходе, 0 - неизвестна
+	Height int    // высота кадра на выходе
+	Filter string // фильтр для -vf, пустой - кадр не меняется
+	Reason string // почему принято такое решение, для логов
+}
+
+// Решаем, нужно ли масштабировать видео до высоты target.
+// Масштабируем только вниз: исходник ниже или равный target остается как есть.
+// Анаморфные исходники (SAR не 1:1) при уменьшении приводятся к квадратным пикселям по DAR
+func ScaleFor(v VideoInfo, target int) Scale {
+	// без данных о видео поступаем как раньше - доверяем профилю
+	if v.Height <= 0 || v.Width <= 0 {
+		if target <= 0 {
+			return Scale{Reason: "source size unknown"}
+		}
+		return Scale{
+			Height: target,
+			Filter: "scale=-2:" + strconv.Itoa(target),
+			Reason: "source size unknown",
+		}
+	}
+
+	sar := parseAspect(v.SAR)
+	if sar <= 0 {
+		sar = 1
+	}
+	dar := parseAspect(v.DAR)
+	if dar <= 0 {
+		dar = float64(v.Width) * sar / float64(v.Height)
+	}
+	anamorphic := math.Abs(sar-1) > 0.01
+
+	if target <= 0 || v.Height <= target {
+		res := Scale{Width: v.Width, Height: v.Height, Reason: "source is not taller than target, no upscale"}
+		// кодеры с yuv420 не принимают нечетные размеры кадра
+		if v.Width%2 != 0 || v.Height%2 != 0 {
+			res.Width = v.Width / 2 * 2
+			res.Height = v.Height / 2 * 2
+			res.Filter = "crop=trunc(iw/2)*2:trunc(ih/2)*2"
+			res.Reason += ", cropped to even size"
+		}
+		return res
+	}
+
+	if anamorphic {
+		width := evenRound(float64(target) * dar)
+		return Scale{
+			Width:  width,
+			Height: target,
+			Filter: "scale=" + strconv.Itoa(width) + ":" + strconv.Itoa(target) + ",setsar=1",
+			Reason: "downscale anamorphic source to square pixels",
+		}
+	}
+
+	return Scale{
+		Width:  evenRound(float64(v.Width) * float64(target) / float64(v.Height)),
+		Height: target,
+		Filter: "scale=-2:" + strconv.Itoa(target),
+		Reason: "downscale",
+	}
+}
+
+// "16:9" => 1.777, "0:1" и пустая строка => 0
+func parseAspect(str string) float64 {
+	num, den, found := strings.Cut(str, ":")
+	if !found {
+		return 0
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Округляем до ближайшего четного
+func evenRound(f float64) int {
+	return int(math.Round(f/2)) * 2
+}
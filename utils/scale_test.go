@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestScaleFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		video    VideoInfo
+		target   int
+		expected Scale
+	}{
+		{
+			name:     "1080p уменьшаем до 720p",
+			video:    VideoInfo{Width: 1920, Height: 1080, SAR: "1:1", DAR: "16:9"},
+			target:   720,
+			expected: Scale{Width: 1280, Height: 720, Filter: "scale=-2:720"},
+		},
+		{
+			name:     "DVD 480p не увеличиваем",
+			video:    VideoInfo{Width: 720, Height: 480, SAR: "32:27", DAR: "16:9"},
+			target:   720,
+			expected: Scale{Width: 720, Height: 480},
+		},
+		{
+			name:     "720p остается как есть",
+			video:    VideoInfo{Width: 1280, Height: 720},
+			target:   720,
+			expected: Scale{Width: 1280, Height: 720},
+		},
+		{
+			name:     "анаморфный 1080i уменьшаем к квадратным пикселям",
+			video:    VideoInfo{Width: 1440, Height: 1080, SAR: "4:3", DAR: "16:9"},
+			target:   720,
+			expected: Scale{Width: 1280, Height: 720, Filter: "scale=1280:720,setsar=1"},
+		},
+		{
+			name:     "анаморфный без DAR",
+			video:    VideoInfo{Width: 1440, Height: 1080, SAR: "4:3"},
+			target:   720,
+			expected: Scale{Width: 1280, Height: 720, Filter: "scale=1280:720,setsar=1"},
+		},
+		{
+			name:     "широкоформатный 2.39:1",
+			video:    VideoInfo{Width: 1920, Height: 804, SAR: "1:1"},
+			target:   720,
+			expected: Scale{Width: 1720, Height: 720, Filter: "scale=-2:720"},
+		},
+		{
+			name:     "нечетный размер без масштабирования",
+			video:    VideoInfo{Width: 853, Height: 479, SAR: "0:1"},
+			target:   720,
+			expected: Scale{Width: 852, Height: 478, Filter: "crop=trunc(iw/2)*2:trunc(ih/2)*2"},
+		},
+		{
+			name:     "профиль без высоты",
+			video:    VideoInfo{Width: 3840, Height: 2160},
+			target:   0,
+			expected: Scale{Width: 3840, Height: 2160},
+		},
+		{
+			name:     "размер исходника неизвестен",
+			video:    VideoInfo{},
+			target:   720,
+			expected: Scale{Height: 720, Filter: "scale=-2:720"},
+		},
+	}
+
+	for _, test := range tests {
+		res := ScaleFor(test.video, test.target)
+		res.Reason = ""
+		if res != test.expected {
+			t.Errorf("%s: ожидалось %+v, получено %+v", test.name, test.expected, res)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import "fmt"
+
+// Сколько файлов кодировать одновременно и сколько потоков дать каждому ffmpeg.
+// Jobs * Threads - общий бюджет процессора
+type Schedule struct {
+	Jobs    int
+	Threads int
+}
+
+func (s Schedule) String() string {
+	return fmt.Sprintf("%d jobs x %d threads", s.Jobs, s.Threads)
+}
+
+// Сколько потоков один кодировщик использует с толком. Больше - и он упирается
+// в зависимости между кадрами, выгоднее запустить еще одно кодирование
+var codecThreads = map[string]int{
+	"libx264":    6,
+	"libx265":    8,
+	"libsvtav1":  8,
+	"libvpx-vp9": 4,
+}
+
+// Делим ядра между одновременными кодированиями.
+// jobs и threads - значения из командной строки, 0 - подобрать самим.
+// Без --threads берется threads из профиля, без него - подходящее для кодека число
+func PlanSchedule(cores int, profile Profile, jobs, threads int) Schedule {
+	if cores < 1 {
+		cores = 1
+	}
+	if threads <= 0 {
+		threads = profile.Threads
+	}
+
+	switch {
+	case jobs > 0 && threads > 0:
+		// оба значения заданы явно - верим пользователю, даже если ядер не хватает
+	case jobs > 0:
+		threads = atLeastOne(cores / jobs)
+	case threads > 0:
+		jobs = atLeastOne(cores / threads)
+	default:
+		threads = codecThreads[profile.Codec]
+		if threads <= 0 || threads > cores {
+			threads = cores
+		}
+		jobs = atLeastOne(cores / threads)
+		// оставшиеся ядра делим между заданиями, чтобы бюджет не простаивал
+		threads = cores / jobs
+	}
+	return Schedule{Jobs: jobs, Threads: threads}
+}
+
+// Потоков на одно кодирование для файла с профилем profile в расписании, составленном
+// по профилю запуска run. Профиль с тем же кодеком и threads получает долю из расписания.
+// Другому профилю - threads из командной строки или профиля, иначе доля ядер на задание,
+// но не больше, чем его кодек использует с толком
+func (s Schedule) ThreadsFor(cores int, run, profile Profile, threads int) int {
+	if profile.Codec == run.Codec && profile.Threads == run.Threads {
+		return s.Threads
+	}
+	if threads <= 0 {
+		threads = profile.Threads
+	}
+	if threads > 0 {
+		return threads
+	}
+	threads = atLeastOne(cores / atLeastOne(s.Jobs))
+	if limit := codecThreads[profile.Codec]; limit > 0 && threads > limit {
+		threads = limit
+	}
+	return threads
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Сколько потоков x265 отдать на параллельные кадры при пуле из threads потоков.
+// Таблица та же, что x265 использует сам, когда frame-threads не задан
+func x265FrameThreads(threads int) int {
+	switch {
+	case threads >= 32:
+		return 6
+	case threads >= 16:
+		return 5
+	case threads >= 8:
+		return 3
+	case threads >= 4:
+		return 2
+	default:
+		return 1
+	}
+}
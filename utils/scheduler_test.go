@@ -0,0 +1,81 @@
+package utils
+
+import "testing"
+
+func TestPlanSchedule(t *testing.T) {
+	hevc := DefaultProfile()
+	withThreads := DefaultProfile()
+	withThreads.Threads = 6
+
+	tests := []struct {
+		name     string
+		cores    int
+		profile  Profile
+		jobs     int
+		threads  int
+		expected Schedule
+	}{
+		{"16 ядер x265", 16, hevc, 0, 0, Schedule{Jobs: 2, Threads: 8}},
+		{"12 ядер x265 - лишние ядра одному заданию", 12, hevc, 0, 0, Schedule{Jobs: 1, Threads: 12}},
+		{"4 ядра x265", 4, hevc, 0, 0, Schedule{Jobs: 1, Threads: 4}},
+		{"32 ядра vp9", 32, Profile{Codec: "libvpx-vp9"}, 0, 0, Schedule{Jobs: 8, Threads: 4}},
+		{"18 ядер x264", 18, Profile{Codec: "libx264"}, 0, 0, Schedule{Jobs: 3, Threads: 6}},
+		{"неизвестное число ядер", 0, hevc, 0, 0, Schedule{Jobs: 1, Threads: 1}},
+		{"только --jobs", 16, hevc, 4, 0, Schedule{Jobs: 4, Threads: 4}},
+		{"--jobs больше ядер", 2, hevc, 4, 0, Schedule{Jobs: 4, Threads: 1}},
+		{"только --threads", 16, hevc, 0, 5, Schedule{Jobs: 3, Threads: 5}},
+		{"--threads больше ядер", 4, hevc, 0, 8, Schedule{Jobs: 1, Threads: 8}},
+		{"оба значения как есть", 4, hevc, 3, 3, Schedule{Jobs: 3, Threads: 3}},
+		{"threads из профиля", 16, withThreads, 0, 0, Schedule{Jobs: 2, Threads: 6}},
+		{"--threads важнее профиля", 16, withThreads, 0, 4, Schedule{Jobs: 4, Threads: 4}},
+	}
+
+	for _, test := range tests {
+		actual := PlanSchedule(test.cores, test.profile, test.jobs, test.threads)
+		if actual != test.expected {
+			t.Errorf("%s: ожидалось %s, получено %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestScheduleThreadsFor(t *testing.T) {
+	hevc := DefaultProfile()
+	av1 := Profile{Name: "av1", Codec: "libsvtav1"}
+	vp9 := Profile{Name: "vp9", Codec: "libvpx-vp9"}
+	vp9Threads := Profile{Name: "vp9", Codec: "libvpx-vp9", Threads: 2}
+
+	// 12 ядер x265: одно задание на все ядра
+	s := PlanSchedule(12, hevc, 0, 0)
+	tests := []struct {
+		name     string
+		profile  Profile
+		threads  int
+		expected int
+	}{
+		{"профиль запуска", hevc, 0, 12},
+		{"другое имя, тот же кодек", Profile{Name: "hevc-anime", Codec: "libx265"}, 0, 12},
+		{"другой кодек - предел кодека", vp9, 0, 4},
+		{"другой кодек - доля ядер", av1, 0, 8},
+		{"threads из профиля", vp9Threads, 0, 2},
+		{"--threads важнее профиля", vp9Threads, 3, 3},
+	}
+	for _, test := range tests {
+		if actual := s.ThreadsFor(12, hevc, test.profile, test.threads); actual != test.expected {
+			t.Errorf("%s: ожидалось %d потоков, получено %d", test.name, test.expected, actual)
+		}
+	}
+
+	// 16 ядер x265: 2 задания по 8, av1 получает свою долю
+	if actual := PlanSchedule(16, hevc, 0, 0).ThreadsFor(16, hevc, av1, 0); actual != 8 {
+		t.Errorf("Ожидалось 8 потоков, получено %d", actual)
+	}
+}
+
+func TestX265FrameThreads(t *testing.T) {
+	tests := map[int]int{1: 1, 3: 1, 4: 2, 8: 3, 12: 3, 16: 5, 64: 6}
+	for threads, expected := range tests {
+		if actual := x265FrameThreads(threads); actual != expected {
+			t.Errorf("pools=%d: ожидалось frame-threads=%d, получено %d", threads, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// Параметры выбора дорожек
+type SelectOptions struct {
+	AudioLangs  []string // предпочтительные языки аудио в порядке приоритета
+	SubsLangs   []string // предпочтительные языки субтитров в порядке приоритета
+	MaxPerLang  int      // сколько дорожек одного языка брать, 0 - все
+	KeepForced  bool     // оставлять форсированные субтитры отдельной дорожкой
+	KeepComment bool     // оставлять аудиокомментарии отдельной дорожкой
+}
+
+// Выбранные для выходного файла дорожки в том порядке, в котором они будут записаны
+type Selection struct {
+	Video VideoInfo
+	Audio Audios
+	Subs  Subs
+}
+
+// ISO 639-2/B коды и двухбуквенные ISO 639-1 коды приводим к ISO 639-2/T,
+// чтобы "ger", "de" и "deu" считались одним языком
+var langAliases = map[string]string{
+	"alb": "sqi", "arm": "hye", "baq": "eus", "bur": "mya", "chi": "zho",
+	"cze": "ces", "dut": "nld", "fre": "fra", "geo": "kat", "ger": "deu",
+	"gre": "ell", "ice": "isl", "mac": "mkd", "mao": "mri", "may": "msa",
+	"per": "fas", "rum": "ron", "slo": "slk", "tib": "bod", "wel": "cym",
+
+	"ar": "ara", "cs": "ces", "da": "dan", "de": "deu", "el": "ell",
+	"en": "eng", "es": "spa", "fi": "fin", "fr": "fra", "he": "heb",
+	"hi": "hin", "hu": "hun", "is": "isl", "it": "ita", "ja": "jpn",
+	"ko": "kor", "nb": "nob", "nl": "nld", "nn": "nno", "no": "nor",
+	"pl": "pol", "pt": "por", "ro": "ron", "ru": "rus", "sv": "swe",
+	"th": "tha", "tr": "tur", "uk": "ukr", "zh": "zho",
+}
+
+// Приводим код языка к единому виду. Пустой язык считаем "und"
+func NormalizeLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return "und"
+	}
+	if alias, ok := langAliases[lang]; ok {
+		return alias
+	}
+	return lang
+}
+
+// Разбираем список языков вида "rus,eng,jpn"
+func ParseLangs(list string) []string {
+	res := make([]string, 0)
+	for _, lang := range strings.Split(list, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			res = append(res, NormalizeLang(lang))
+		}
+	}
+	return res
+}
+
+// Выбираем дорожки по списку предпочтительных языков
+func SelectStreams(info AllStreamInfo, opts SelectOptions) Selection {
+	res := Selection{Video: info.Video, Audio: make(Audios, 0), Subs: make(Subs, 0)}
+
+	for _, lang := range uniqueLangs(opts.AudioLangs) {
+		res.Audio = append(res.Audio, selectAudio(info.Audio, lang, opts)...)
+	}
+
+	for _, lang := range uniqueLangs(opts.SubsLangs) {
+		res.Subs = append(res.Subs, selectSubs(info.Subs, lang, opts)...)
+	}
+
+	return res
+}
+
+// Выбираем аудиодорожки одного языка: основные по рейтингу RankAudio,
+// комментарии по желанию добавляются отдельной дорожкой, тифлокомментарии отбрасываются
+func selectAudio(audio Audios, lang string, opts SelectOptions) Audios {
+	primary := make(Audios, 0)
+	var comment *AudioInfo
+	for i, a := range audio {
+		if NormalizeLang(a.Language) != lang {
+			continue
+		}
+		switch a.Kind {
+		case AudioMain:
+			primary = append(primary, a)
+		case AudioCommentary:
+			if comment == nil {
+				comment = &audio[i]
+			}
+		}
+	}
+
+	primary = RankAudio(primary)
+	if opts.MaxPerLang > 0 && len(primary) > opts.MaxPerLang {
+		primary = primary[:opts.MaxPerLang]
+	}
+
+	// комментарии без основной дорожки не нужны
+	if opts.KeepComment && comment != nil && len(primary) > 0 {
+		primary = append(primary, *comment)
+	}
+	return primary
+}
+
+// Выбираем субтитры одного языка: основными идут полные, при их отсутствии - SDH,
+// форсированные по желанию добавляются отдельной дорожкой, комментарии отбрасываются
+func selectSubs(subs Subs, lang string, opts SelectOptions) Subs {
+	primary := make(Subs, 0)
+	var forced *SubsInfo
+	for i, s := range subs {
+		if NormalizeLang(s.Language) != lang {
+			continue
+		}
+		switch s.Kind {
+		case SubsFull, SubsSDH:
+			primary = append(primary, s)
+		case SubsForced:
+			if forced == nil {
+				forced = &subs[i]
+			}
+		}
+	}
+
+	sort.SliceStable(primary, func(i, j int) bool {
+		if primary[i].Kind != primary[j].Kind {
+			return primary[i].Kind == SubsFull
+		}
+		return primary[i].Frames > primary[j].Frames
+	})
+	if opts.MaxPerLang > 0 && len(primary) > opts.MaxPerLang {
+		primary = primary[:opts.MaxPerLang]
+	}
+
+	if opts.KeepForced && forced != nil {
+		primary = append(primary, *forced)
+	}
+	return primary
+}
+
+// Убираем повторы, сохраняя порядок приоритета
+func uniqueLangs(langs []string) []string {
+	res := make([]string, 0, len(langs))
+	seen := make(map[string]bool)
+	for _, lang := range langs {
+		lang = NormalizeLang(lang)
+		if !seen[lang] {
+			seen[lang] = true
+			res = append(res, lang)
+		}
+	}
+	return res
+}
+
+// Краткое описание выбранных дорожек для логов: "a:[rus eng] s:[eng]"
+func (sel Selection) String() string {
+	return "a:[" + strings.Join(sel.AudioLabels(), " ") + "] s:[" + strings.Join(sel.SubsLabels(), " ") + "]"
+}
+
+// Языки выбранных аудиодорожек, у неосновных - с видом: "eng(commentary)"
+func (sel Selection) AudioLabels() []string {
+	audio := make([]string, 0, len(sel.Audio))
+	for _, a := range sel.Audio {
+		if a.Kind != AudioMain {
+			audio = append(audio, NormalizeLang(a.Language)+"("+a.Kind.String()+")")
+			continue
+		}
+		audio = append(audio, NormalizeLang(a.Language))
+	}
+	return audio
+}
+
+// Языки выбранных субтитров, у неполных - с видом: "rus(forced)"
+func (sel Selection) SubsLabels() []string {
+	subs := make([]string, 0, len(sel.Subs))
+	for _, s := range sel.Subs {
+		if s.Kind != SubsFull {
+			subs = append(subs, NormalizeLang(s.Language)+"("+s.Kind.String()+")")
+			continue
+		}
+		subs = append(subs, NormalizeLang(s.Language))
+	}
+	return subs
+}
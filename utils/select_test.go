@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testStreamInfo() AllStreamInfo {
+	return AllStreamInfo{
+		Audio: Audios{
+			{Index: 1, TypeIndex: 0, Language: "rus"},
+			{Index: 2, TypeIndex: 1, Language: "eng"},
+			{Index: 3, TypeIndex: 2, Language: "jpn"},
+			{Index: 4, TypeIndex: 3, Language: "rus"},
+		},
+		Subs: Subs{
+			{Index: 5, TypeIndex: 0, Language: "rus"},
+			{Index: 6, TypeIndex: 1, Language: "rus"},
+			{Index: 7, TypeIndex: 2, Language: "eng"},
+			{Index: 8, TypeIndex: 3},
+		},
+	}
+}
+
+func typeIndexes(sel Selection) ([]int, []int) {
+	audio := make([]int, 0)
+	for _, a := range sel.Audio {
+		audio = append(audio, a.TypeIndex)
+	}
+	subs := make([]int, 0)
+	for _, s := range sel.Subs {
+		subs = append(subs, s.TypeIndex)
+	}
+	return audio, subs
+}
+
+func TestSelectStreams(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          SelectOptions
+		expectedAudio []int
+		expectedSubs  []int
+	}{
+		{
+			name:          "порядок по предпочтению",
+			opts:          SelectOptions{AudioLangs: []string{"jpn", "rus"}, SubsLangs: []string{"eng", "rus"}, MaxPerLang: 1},
+			expectedAudio: []int{2, 0},
+			expectedSubs:  []int{2, 0},
+		},
+		{
+			name:          "без ограничения на язык",
+			opts:          SelectOptions{AudioLangs: []string{"rus"}, SubsLangs: []string{"rus"}},
+			expectedAudio: []int{0, 3},
+			expectedSubs:  []int{0, 1},
+		},
+		{
+			name:          "двухбуквенные коды и повторы",
+			opts:          SelectOptions{AudioLangs: []string{"en", "eng", "ja"}, SubsLangs: []string{"und"}, MaxPerLang: 1},
+			expectedAudio: []int{1, 2},
+			expectedSubs:  []int{3},
+		},
+		{
+			name:          "нет подходящих языков",
+			opts:          SelectOptions{AudioLangs: []string{"swe"}, SubsLangs: []string{"nor"}, MaxPerLang: 1},
+			expectedAudio: []int{},
+			expectedSubs:  []int{},
+		},
+	}
+
+	for _, test := range tests {
+		audio, subs := typeIndexes(SelectStreams(testStreamInfo(), test.opts))
+		if !reflect.DeepEqual(audio, test.expectedAudio) {
+			t.Errorf("%s: ожидались аудиодорожки %v, получено %v", test.name, test.expectedAudio, audio)
+		}
+		if !reflect.DeepEqual(subs, test.expectedSubs) {
+			t.Errorf("%s: ожидались субтитры %v, получено %v", test.name, test.expectedSubs, subs)
+		}
+	}
+}
+
+func TestParseLangs(t *testing.T) {
+	expected := []string{"rus", "deu", "deu", "swe"}
+	if langs := ParseLangs(" rus, ger,DE,,sv "); !reflect.DeepEqual(langs, expected) {
+		t.Errorf("Ожидалось %v, получено %v", expected, langs)
+	}
+}
+
+func TestSelectStreamsSubsPolicy(t *testing.T) {
+	info := AllStreamInfo{
+		Audio: Audios{{TypeIndex: 0, Language: "eng"}},
+		Subs: Subs{
+			{TypeIndex: 0, Language: "eng", Kind: SubsForced, Frames: 30},
+			{TypeIndex: 1, Language: "eng", Kind: SubsSDH, Frames: 900},
+			{TypeIndex: 2, Language: "eng", Kind: SubsFull, Frames: 700},
+			{TypeIndex: 3, Language: "eng", Kind: SubsCommentary, Frames: 1200},
+			{TypeIndex: 4, Language: "rus", Kind: SubsSDH, Frames: 650},
+			{TypeIndex: 5, Language: "rus", Kind: SubsForced, Frames: 20},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opts     SelectOptions
+		expected []int
+	}{
+		{
+			name:     "полные основными, форсированные отдельно",
+			opts:     SelectOptions{SubsLangs: []string{"eng", "rus"}, MaxPerLang: 1, KeepForced: true},
+			expected: []int{2, 0, 4, 5},
+		},
+		{
+			name:     "без форсированных",
+			opts:     SelectOptions{SubsLangs: []string{"eng", "rus"}, MaxPerLang: 1},
+			expected: []int{2, 4},
+		},
+		{
+			name:     "комментарии не берутся никогда",
+			opts:     SelectOptions{SubsLangs: []string{"eng"}},
+			expected: []int{2, 1},
+		},
+	}
+
+	for _, test := range tests {
+		_, subs := typeIndexes(SelectStreams(info, test.opts))
+		if !reflect.DeepEqual(subs, test.expected) {
+			t.Errorf("%s: ожидались субтитры %v, получено %v", test.name, test.expected, subs)
+		}
+	}
+}
+
+func TestSelectStreamsAudioPolicy(t *testing.T) {
+	info := AllStreamInfo{
+		Audio: Audios{
+			{TypeIndex: 0, Language: "rus", Kind: AudioCommentary, Channels: 2},
+			{TypeIndex: 1, Language: "rus", Channels: 2, BitRate: 192000},
+			{TypeIndex: 2, Language: "rus", Channels: 6, BitRate: 384000},
+			{TypeIndex: 3, Language: "eng", Kind: AudioDescription, Channels: 6},
+			{TypeIndex: 4, Language: "eng", Channels: 6},
+			{TypeIndex: 5, Language: "jpn", Kind: AudioCommentary, Channels: 2},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opts     SelectOptions
+		expected []int
+	}{
+		{
+			name:     "лучшая основная дорожка",
+			opts:     SelectOptions{AudioLangs: []string{"rus", "eng", "jpn"}, MaxPerLang: 1},
+			expected: []int{2, 4},
+		},
+		{
+			name:     "с комментариями",
+			opts:     SelectOptions{AudioLangs: []string{"rus", "eng", "jpn"}, MaxPerLang: 1, KeepComment: true},
+			expected: []int{2, 0, 4},
+		},
+		{
+			name:     "все основные дорожки",
+			opts:     SelectOptions{AudioLangs: []string{"rus"}},
+			expected: []int{2, 1},
+		},
+	}
+
+	for _, test := range tests {
+		audio, _ := typeIndexes(SelectStreams(info, test.opts))
+		if !reflect.DeepEqual(audio, test.expected) {
+			t.Errorf("%s: ожидались аудиодорожки %v, получено %v", test.name, test.expected, audio)
+		}
+	}
+}
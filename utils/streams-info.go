@@ -1,245 +1,401 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"math"
 	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const (
-	// Константы для регулярных выражений для поиска строк с информацией о потоках
-	audioStartPtrn    = `^\s*Stream\s*#\d:(\d\d?)\(\w\w\w\): Audio:`
-	subtitleStartPtrn = `^\s*Stream\s*#\d:(\d\d?)\(\w\w\w\): Subtitle:`
+var pixFmtDepthPattern = regexp.MustCompile(`(?:p|^p0)(\d\d)(le|be)?$`)
 
-	// audioPatternRusPtrn    = `^\s*Stream\s*#0:(\d\d?)\(rus\): Audio:`
-	// audioPatternEngPtrn    = `^\s*Stream\s*#0:(\d\d?)\(eng\): Audio:`
-	// subtitlePatternRusPtrn = `^\s*Stream\s*#0:(\d\d?)\(rus\): Subtitle:`
-	// subtitlePatternEngPtrn = `^\s*Stream\s*#0:(\d\d?)\(eng\): Subtitle:`
-
-	videoPtrn = `^Stream\s*#\d:\d\d?\(\w+\):\s*Video:\s*.*,\s*(\d{3}\d?)x(\d{3}\d?)\s*.*$`
-)
-
-var (
-	// Компилируем регулярные выражения для поиска строк с информацией о потоках
-	audioStart    = regexp.MustCompile(audioStartPtrn)
-	subtitleStart = regexp.MustCompile(subtitleStartPtrn)
-
-	// audioPatternRus    = regexp.MustCompile(audioPatternRusPtrn)
-	// audioPatternEng    = regexp.MustCompile(audioPatternEngPtrn)
-	// subtitlePatternRus = regexp.MustCompile(subtitlePatternRusPtrn)
-	// subtitlePatternEng = regexp.MustCompile(subtitlePatternEngPtrn)
-
-	videoPattern = regexp.MustCompile(videoPtrn)
-)
+// Флаги disposition потока
+type Disposition struct {
+	Default         bool
+	Forced          bool
+	HearingImpaired bool
+	VisualImpaired  bool
+	Comment         bool
+}
 
 // Структура для хранения информации о видео
 type VideoInfo struct {
-	Width  int
-	Height int
+	Index     int // абсолютный индекс потока в файле (0:N)
+	TypeIndex int // индекс среди видеопотоков (0:v:N)
+	Codec     string
+	Profile   string
+	PixFmt    string
+	BitDepth  int
+	FrameRate float64
+	Width     int
+	Height    int
+	SAR       string // соотношение сторон пикселя, например "32:27"
+	DAR       string // соотношение сторон кадра, например "16:9"
+	Duration  time.Duration
+	BitRate   int64
+	Title     string
+	Language  string
+	Disposition
 }
 
-// Структура для хранения информации об аудиопотоке (аудио или субтитры)
+// Структура для хранения информации об аудиопотоке
 type AudioInfo struct {
-	Index int
-	// Offset   int
-	Title    string
-	Language string
+	Index         int // абсолютный индекс потока в файле (0:N)
+	TypeIndex     int // индекс среди аудиопотоков (0:a:N)
+	Codec         string
+	Profile       string
+	Channels      int
+	ChannelLayout string
+	SampleRate    int
+	Duration      time.Duration
+	BitRate       int64
+	Title         string
+	Language      string
+	Kind          AudioKind
+	Disposition
 }
 
 type Audios []AudioInfo
 
 // Структура для хранения информации о субтитрах
 type SubsInfo struct {
-	Index int
-	// Offset   int
-	Title    string
-	Language string
+	Index     int // абсолютный индекс потока в файле (0:N)
+	TypeIndex int // индекс среди субтитров (0:s:N)
+	Codec     string
+	Duration  time.Duration
+	BitRate   int64
+	Frames    int64 // количество реплик (NUMBER_OF_FRAMES)
+	Bytes     int64 // размер потока (NUMBER_OF_BYTES)
+	Title     string
+	Language  string
+	Kind      SubsKind
+	Disposition
 }
 
 type Subs []SubsInfo
 
 // Структура для хранения всей информации
 type AllStreamInfo struct {
-	v VideoInfo
-	a Audios
-	s Subs
+	Video VideoInfo
+	Audio Audios
+	Subs  Subs
+
+	// данные контейнера
+	Duration time.Duration
+	BitRate  int64
+	Size     int64
 }
 
 func NewAllStreamInfo() *AllStreamInfo {
-	v := VideoInfo{}
-	// a := AudioInfo{Index: -1}
-	// s := SubsInfo{Index: -1}
-	a := make(Audios, 0)
-	s := make(Subs, 0)
-	return &AllStreamInfo{v: v, a: a, s: s}
+	return &AllStreamInfo{
+		Video: VideoInfo{Index: -1, TypeIndex: -1},
+		Audio: make(Audios, 0),
+		Subs:  make(Subs, 0),
+	}
 }
 
-func parseIndex(str string) int {
-	var index int
-	_, err := fmt.Sscanf(str, "%d", &index)
+// Вывод ffprobe -print_format json -show_streams -show_format -show_chapters
+type probeOutput struct {
+	Streams  []probeStream  `json:"streams"`
+	Format   probeFormat    `json:"format"`
+	Chapters []probeChapter `json:"chapters"`
+}
+
+// Описание одного потока в выводе ffprobe
+type probeStream struct {
+	Index         int               `json:"index"`
+	CodecName     string            `json:"codec_name"`
+	CodecType     string            `json:"codec_type"`
+	Profile       string            `json:"profile"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	PixFmt        string            `json:"pix_fmt"`
+	BitsPerRaw    string            `json:"bits_per_raw_sample"`
+	SampleAR      string            `json:"sample_aspect_ratio"`
+	DisplayAR     string            `json:"display_aspect_ratio"`
+	AvgFrameRate  string            `json:"avg_frame_rate"`
+	RFrameRate    string            `json:"r_frame_rate"`
+	SampleRate    string            `json:"sample_rate"`
+	Channels      int               `json:"channels"`
+	ChannelLayout string            `json:"channel_layout"`
+	BitRate       string            `json:"bit_rate"`
+	Duration      string            `json:"duration"`
+	NbFrames      string            `json:"nb_frames"`
+	Disposition   map[string]int    `json:"disposition"`
+	Tags          map[string]string `json:"tags"`
+}
+
+// Описание контейнера в выводе ffprobe
+type probeFormat struct {
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	NbStreams  int               `json:"nb_streams"`
+	Tags       map[string]string `json:"tags"`
+}
+
+// Описание главы в выводе ffprobe
+type probeChapter struct {
+	ID        int64             `json:"id"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// Выполняем ffprobe и получаем информацию о файле в формате JSON
+func GetRawInfo(ctx context.Context, file string) ([]byte, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		"-show_chapters",
+		file,
+	)
+	ownProcessGroup(cmd)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, &ProbeError{File: file, Err: ErrToolNotFound}
+	}
+	if ctx.Err() != nil {
+		return nil, &ProbeError{File: file, Err: ctx.Err()}
+	}
 	if err != nil {
-		return 0
+		return nil, &ProbeError{File: file, Err: err, Stderr: tailLines(stderr.Bytes(), stderrTailLines)}
 	}
-	return index
+
+	return out, nil
+}
+
+// Разбираем JSON от ffprobe в типизированные структуры
+func parseProbeOutput(data []byte) (*probeOutput, error) {
+	var out probeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("не удалось разобрать вывод ffprobe: %w", err)
+	}
+	return &out, nil
+}
+
+// Заполняем AllStreamInfo по разобранному выводу ffprobe
+func newAllStreamInfo(probe *probeOutput) *AllStreamInfo {
+	res := NewAllStreamInfo()
+	res.Duration = parseSeconds(probe.Format.Duration)
+	res.BitRate = parseInt64(probe.Format.BitRate)
+	res.Size = parseInt64(probe.Format.Size)
+
+	var numVideo, numAudio, numSubs int
+	for _, st := range probe.Streams {
+		switch st.CodecType {
+		case "video":
+			typeIndex := numVideo
+			numVideo++
+			// обложки в mkv тоже видеопотоки, берем только первый настоящий
+			if res.Video.Index >= 0 || st.Disposition["attached_pic"] == 1 {
+				continue
+			}
+			res.Video = handleVideo(st, typeIndex)
+		case "audio":
+			res.Audio = append(res.Audio, handleAudio(st, numAudio))
+			numAudio++
+		case "subtitle":
+			res.Subs = append(res.Subs, handleSubtitle(st, numSubs))
+			numSubs++
+		}
+	}
+
+	res.Audio = ClassifyAudio(res.Audio)
+	res.Subs = ClassifySubs(res.Subs)
+
+	// у mkv длительность потоков обычно есть только в тегах, берем ее из контейнера
+	if res.Video.Duration == 0 {
+		res.Video.Duration = res.Duration
+	}
+
+	return res
 }
 
-func GetRawInfo(file string, outputFile *os.File) []string {
-	// Выполняем команду ffprobe и получаем вывод
+func handleVideo(st probeStream, typeIndex int) VideoInfo {
+	bitDepth := parseInt(st.BitsPerRaw)
+	if bitDepth == 0 {
+		bitDepth = pixFmtBitDepth(st.PixFmt)
+	}
+	frameRate := parseRational(st.AvgFrameRate)
+	if frameRate == 0 {
+		frameRate = parseRational(st.RFrameRate)
+	}
+	return VideoInfo{
+		Index:       st.Index,
+		TypeIndex:   typeIndex,
+		Codec:       st.CodecName,
+		Profile:     st.Profile,
+		PixFmt:      st.PixFmt,
+		BitDepth:    bitDepth,
+		FrameRate:   frameRate,
+		Width:       st.Width,
+		Height:      st.Height,
+		SAR:         st.SampleAR,
+		DAR:         st.DisplayAR,
+		Duration:    streamDuration(st),
+		BitRate:     streamBitRate(st),
+		Title:       st.Tags["title"],
+		Language:    st.Tags["language"],
+		Disposition: newDisposition(st.Disposition),
+	}
+}
 
-	cmd := exec.Command("ffprobe", "-i", file)
+func handleAudio(st probeStream, typeIndex int) AudioInfo {
+	return AudioInfo{
+		Index:         st.Index,
+		TypeIndex:     typeIndex,
+		Codec:         st.CodecName,
+		Profile:       st.Profile,
+		Channels:      st.Channels,
+		ChannelLayout: st.ChannelLayout,
+		SampleRate:    parseInt(st.SampleRate),
+		Duration:      streamDuration(st),
+		BitRate:       streamBitRate(st),
+		Title:         st.Tags["title"],
+		Language:      st.Tags["language"],
+		Disposition:   newDisposition(st.Disposition),
+	}
+}
 
-	cmd.Stderr = outputFile
+func handleSubtitle(st probeStream, typeIndex int) SubsInfo {
+	return SubsInfo{
+		Index:       st.Index,
+		TypeIndex:   typeIndex,
+		Codec:       st.CodecName,
+		Duration:    streamDuration(st),
+		BitRate:     streamBitRate(st),
+		Frames:      streamFrames(st),
+		Bytes:       parseInt64(st.Tags["NUMBER_OF_BYTES"]),
+		Title:       st.Tags["title"],
+		Language:    st.Tags["language"],
+		Disposition: newDisposition(st.Disposition),
+	}
+}
 
-	// Выполняем команду
-	err := cmd.Run()
+func newDisposition(d map[string]int) Disposition {
+	return Disposition{
+		Default:         d["default"] == 1,
+		Forced:          d["forced"] == 1,
+		HearingImpaired: d["hearing_impaired"] == 1,
+		VisualImpaired:  d["visual_impaired"] == 1,
+		Comment:         d["comment"] == 1,
+	}
+}
+
+// Длительность потока: поле duration, а для mkv - тег DURATION
+func streamDuration(st probeStream) time.Duration {
+	if d := parseSeconds(st.Duration); d > 0 {
+		return d
+	}
+	return parseClock(st.Tags["DURATION"])
+}
+
+// Битрейт потока: поле bit_rate, а для mkv - тег BPS
+func streamBitRate(st probeStream) int64 {
+	if b := parseInt64(st.BitRate); b > 0 {
+		return b
+	}
+	return parseInt64(st.Tags["BPS"])
+}
+
+// Количество кадров потока: поле nb_frames, а для mkv - тег NUMBER_OF_FRAMES
+func streamFrames(st probeStream) int64 {
+	if n := parseInt64(st.NbFrames); n > 0 {
+		return n
+	}
+	return parseInt64(st.Tags["NUMBER_OF_FRAMES"])
+}
+
+// Глубина цвета по имени формата пикселей: yuv420p10le => 10
+func pixFmtBitDepth(pixFmt string) int {
+	if pixFmt == "" {
+		return 0
+	}
+	if m := pixFmtDepthPattern.FindStringSubmatch(pixFmt); m != nil {
+		return parseInt(m[1])
+	}
+	return 8
+}
+
+func parseInt(str string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(str))
 	if err != nil {
-		log.Fatalf("Ошибка при выполнении команды: %s\n", err)
-	}
-
-	return ReadFileAndSplit(outputFile.Name())
-}
-
-// Функция для получения информации о потоках (аудио или субтитры) с помощью ffprobe
-func GetStreamsInfo(file string) AllStreamInfo {
-	// ffprobe -i Beforeigners.S01E01.1080p.HMAX.WEB-DL.DD5.1.H.264-BLS.mkv
-
-	// Input #0, matroska,webm, from '.\Beforeigners.S01E01.1080p.HMAX.WEB-DL.DD5.1.H.264-BLS.mkv':
-	//   Metadata:
-	//     encoder         : libebml v1.4.2 + libmatroska v1.6.4
-	//     creation_time   : 2022-04-07T06:47:45.000000Z
-	//   Duration: 00:48:59.00, start: 0.000000, bitrate: 8984 kb/s
-	//   Stream #0:0: Video: h264 (High), yuv420p(tv, bt709, progressive), 1920x1080 [SAR 1:1 DAR 16:9], 25 fps, 25 tbr, 1k tbn (default)
-	//     Metadata:
-	//       BPS             : 8214064
-	//       DURATION        : 00:48:59.000000000
-	//       NUMBER_OF_FRAMES: 73475
-	//       NUMBER_OF_BYTES : 3017641915
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-	//   Stream #0:1(rus): Audio: ac3, 48000 Hz, 5.1(side), fltp, 384 kb/s (default)
-	//     Metadata:
-	//       title           : Кириллица
-	//       BPS             : 384000
-	//       DURATION        : 00:48:58.976000000
-	//       NUMBER_OF_FRAMES: 91843
-	//       NUMBER_OF_BYTES : 141070848
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-	//   Stream #0:2(nor): Audio: ac3, 48000 Hz, 5.1(side), fltp, 384 kb/s
-	//     Metadata:
-	//       BPS             : 384000
-	//       DURATION        : 00:48:58.976000000
-	//       NUMBER_OF_FRAMES: 91843
-	//       NUMBER_OF_BYTES : 141070848
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-	//   Stream #0:3(rus): Subtitle: subrip
-	//     Metadata:
-	//       title           : Кириллица
-	//       BPS             : 98
-	//       DURATION        : 00:45:37.590000000
-	//       NUMBER_OF_FRAMES: 547
-	//       NUMBER_OF_BYTES : 33648
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-	//   Stream #0:4(nor): Subtitle: subrip
-	//     Metadata:
-	//       BPS             : 52
-	//       DURATION        : 00:48:08.440000000
-	//       NUMBER_OF_FRAMES: 401
-	//       NUMBER_OF_BYTES : 18935
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-	//   Stream #0:5(eng): Subtitle: subrip
-	//     Metadata:
-	//       BPS             : 55
-	//       DURATION        : 00:45:36.800000000
-	//       NUMBER_OF_FRAMES: 416
-	//       NUMBER_OF_BYTES : 18943
-	//       _STATISTICS_WRITING_APP: mkvmerge v65.0.0 ('Too Much') 64-bit
-	//       _STATISTICS_WRITING_DATE_UTC: 2022-04-07 06:47:45
-	//       _STATISTICS_TAGS: BPS DURATION NUMBER_OF_FRAMES NUMBER_OF_BYTES
-
-	// TODO: не брать форсированные субтитры. Пока же просто берутся ПОСЛЕДНИЕ в списке, потому что обычно первые это форсированные
-
-	var (
-		isRusA bool
-		isEngA bool
-		// isRusS  bool
-		// isEngS  bool
-		errFile = file + ".txt"
-	)
+		return 0
+	}
+	return n
+}
+
+func parseInt64(str string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
 
-	// Создаем файл куда будет записана информация о файле
-	outputFile := CreateFile(errFile)
-	// в defer делаем закрытие и удаление этого файла
-	defer func() {
-		RemoveFile(errFile, outputFile)
-	}()
+// "2939.000000" => 48m59s
+func parseSeconds(str string) time.Duration {
+	f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(math.Round(f * float64(time.Second)))
+}
 
-	res := NewAllStreamInfo()
+// "00:48:59.000000000" => 48m59s
+func parseClock(str string) time.Duration {
+	var h, m int
+	var s float64
+	if _, err := fmt.Sscanf(str, "%d:%d:%f", &h, &m, &s); err != nil {
+		return 0
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(math.Round(s*float64(time.Second)))
+}
 
-	// Получаем информацию из файла вывода ffprobe
-	lines := GetRawInfo(file, outputFile)
+// "24000/1001" => 23.976
+func parseRational(str string) float64 {
+	num, den, found := strings.Cut(str, "/")
+	if !found {
+		f, _ := strconv.ParseFloat(str, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
 
-	leng := len(lines)
-	for i := 0; i < leng; i++ {
-		if i >= leng {
-			break
-		}
-		line := lines[i]
-		if match := videoPattern.FindStringSubmatch(line); match != nil {
-			// обрабатывать пока не закончится видео раздел
-			// handleVideo()
-			// изменить i
-			continue
-		} else if match := audioStart.FindStringSubmatch(line); match != nil {
-			// обрабатывать пока не закончится аудио раздел
-			// handleAudio()
-			// изменить i
-			continue
-		} else if match := subtitleStart.FindStringSubmatch(line); match != nil {
-			// обрабатывать пока не закончится раздел субтитров
-			// subtitleHandle()
-			// изменить i
-			continue
-		}
+// Функция для получения информации о потоках (видео, аудио и субтитры) с помощью ffprobe
+func GetStreamsInfo(ctx context.Context, file string) (AllStreamInfo, error) {
+	raw, err := GetRawInfo(ctx, file)
+	if err != nil {
+		return AllStreamInfo{}, err
 	}
 
-	for _, line := range lines {
-		if match := videoPattern.FindStringSubmatch(line); match != nil {
-			handleVideo()
-		} else if match := audioStart.FindStringSubmatch(line); match != nil {
-			handleAudio()
-		} else if match := subtitleStart.FindStringSubmatch(line); match != nil {
-			subtitleHandle()
-		}
+	probe, err := parseProbeOutput(raw)
+	if err != nil {
+		return AllStreamInfo{}, &ProbeError{File: file, Err: err}
+	}
 
-		// if match := audioPatternRus.FindStringSubmatch(line); match != nil && !isRusA {
-		// 	isRusA = true
-		// 	index := parseIndex(match[1]) - startAudio
-		// 	res.UpdateIndex("rusAudio", index)
-		// } else if match := audioPatternEng.FindStringSubmatch(line); match != nil && !isEngA {
-		// 	isEngA = true
-		// 	index := parseIndex(match[1]) - startAudio
-		// 	res.UpdateIndex("engAudio", index)
-		// } else if match := subtitlePatternRus.FindStringSubmatch(line); match != nil { //&& !isRusS {
-		// 	// isRusS = true
-		// 	index := parseIndex(match[1]) - startSub
-		// 	res.UpdateIndex("rusSubs", index)
-		// } else if match := subtitlePatternEng.FindStringSubmatch(line); match != nil { //&& !isEngS {
-		// 	// isEngS = true
-		// 	index := parseIndex(match[1]) - startSub
-		// 	res.UpdateIndex("engSubs", index)
-		// }
-	}
-
-	return *res
+	return *newAllStreamInfo(probe), nil
 }
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	data, err := os.ReadFile("testdata/beforeigners.json")
+	if err != nil {
+		t.Fatalf("Ошибка при чтении тестового файла: %s", err)
+	}
+
+	probe, err := parseProbeOutput(data)
+	if err != nil {
+		t.Fatalf("Ошибка при разборе вывода ffprobe: %s", err)
+	}
+	if len(probe.Streams) != 7 {
+		t.Errorf("Ожидалось 7 потоков, получено %d", len(probe.Streams))
+	}
+	if len(probe.Chapters) != 1 {
+		t.Errorf("Ожидалась 1 глава, получено %d", len(probe.Chapters))
+	}
+	if probe.Format.Duration != "2939.000000" {
+		t.Errorf("Ожидалась длительность 2939.000000, получено %q", probe.Format.Duration)
+	}
+
+	info := newAllStreamInfo(probe)
+	if info.Duration != 2939*time.Second {
+		t.Errorf("Ожидалась длительность файла 48m59s, получено %s", info.Duration)
+	}
+	if info.Size != 3300584345 || info.BitRate != 8984231 {
+		t.Errorf("Неверные размер/битрейт контейнера: %d/%d", info.Size, info.BitRate)
+	}
+
+	expectedVideo := VideoInfo{
+		Index:       0,
+		TypeIndex:   0,
+		Codec:       "h264",
+		Profile:     "High",
+		PixFmt:      "yuv420p",
+		BitDepth:    8,
+		FrameRate:   25,
+		Width:       1920,
+		Height:      1080,
+		SAR:         "1:1",
+		DAR:         "16:9",
+		Duration:    2939 * time.Second,
+		BitRate:     8214064,
+		Disposition: Disposition{Default: true},
+	}
+	if info.Video != expectedVideo {
+		t.Errorf("Ожидалось видео %+v, получено %+v", expectedVideo, info.Video)
+	}
+
+	expectedAudio := Audios{
+		{
+			Index: 1, TypeIndex: 0, Codec: "ac3", Channels: 6, ChannelLayout: "5.1(side)", SampleRate: 48000,
+			Duration: 2938976 * time.Millisecond, BitRate: 384000, Title: "Кириллица", Language: "rus",
+			Disposition: Disposition{Default: true},
+		},
+		{
+			Index: 2, TypeIndex: 1, Codec: "ac3", Channels: 6, ChannelLayout: "5.1(side)", SampleRate: 48000,
+			Duration: 2938976 * time.Millisecond, BitRate: 384000, Language: "nor",
+		},
+	}
+	if !equalAudios(info.Audio, expectedAudio) {
+		t.Errorf("Ожидались аудиопотоки %+v, получено %+v", expectedAudio, info.Audio)
+	}
+
+	expectedSubs := Subs{
+		{Index: 3, TypeIndex: 0, Codec: "subrip", Duration: 2737590 * time.Millisecond, BitRate: 98, Frames: 547, Bytes: 33648, Title: "Кириллица", Language: "rus"},
+		{Index: 4, TypeIndex: 1, Codec: "subrip", Duration: 2888440 * time.Millisecond, BitRate: 52, Frames: 401, Bytes: 18935, Language: "nor"},
+		{Index: 5, TypeIndex: 2, Codec: "subrip", Duration: 2736800 * time.Millisecond, BitRate: 55, Frames: 416, Bytes: 18943, Language: "eng"},
+		{Index: 6, TypeIndex: 3, Codec: "subrip"},
+	}
+	if !equalSubs(info.Subs, expectedSubs) {
+		t.Errorf("Ожидались субтитры %+v, получено %+v", expectedSubs, info.Subs)
+	}
+}
+
+func TestPixFmtBitDepth(t *testing.T) {
+	tests := map[string]int{
+		"":            0,
+		"yuv420p":     8,
+		"yuv420p10le": 10,
+		"yuv444p12be": 12,
+		"p010le":      10,
+		"yuvj420p":    8,
+	}
+	for pixFmt, expected := range tests {
+		if depth := pixFmtBitDepth(pixFmt); depth != expected {
+			t.Errorf("Для %q ожидалась глубина %d, получено %d", pixFmt, expected, depth)
+		}
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	if fps := parseRational("24000/1001"); fps < 23.975 || fps > 23.977 {
+		t.Errorf("Ожидалось 23.976, получено %f", fps)
+	}
+	if fps := parseRational("0/0"); fps != 0 {
+		t.Errorf("Ожидалось 0, получено %f", fps)
+	}
+}
+
+func TestParseProbeOutputInvalid(t *testing.T) {
+	if _, err := parseProbeOutput([]byte("Stream #0:1(rus): Audio: ac3")); err == nil {
+		t.Error("Ожидалась ошибка для вывода не в формате JSON")
+	}
+}
+
+func equalAudios(a, b Audios) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSubs(a, b Subs) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Насколько длительность готового файла может отличаться от исходника
+const durationTolerance = 2 * time.Second
+
+// Каким должен получиться выходной файл
+type Expectation struct {
+	Duration time.Duration // длительность исходника, 0 - не проверять
+	Height   int           // высота кадра, 0 - не проверять
+	Audio    []string      // языки аудиодорожек по порядку
+	Subs     []string      // языки субтитров по порядку
+}
+
+// Ожидаемый результат для выбранных дорожек и высоты кадра
+func ExpectedOutput(sel Selection, height int) Expectation {
+	want := Expectation{
+		Duration: sel.Video.Duration,
+		Height:   height,
+		Audio:    make([]string, 0, len(sel.Audio)),
+		Subs:     make([]string, 0, len(sel.Subs)),
+	}
+	for _, a := range sel.Audio {
+		want.Audio = append(want.Audio, NormalizeLang(a.Language))
+	}
+	for _, s := range sel.Subs {
+		want.Subs = append(want.Subs, NormalizeLang(s.Language))
+	}
+	return want
+}
+
+// Сравниваем данные ffprobe о готовом файле с ожидаемыми.
+// Пустая строка - файл в порядке, иначе - что с ним не так
+func (want Expectation) mismatch(info AllStreamInfo) string {
+	if info.Video.TypeIndex < 0 {
+		return "no video stream"
+	}
+	if want.Height > 0 && info.Video.Height != want.Height {
+		return fmt.Sprintf("video height %dp instead of %dp", info.Video.Height, want.Height)
+	}
+
+	audio := make([]string, 0, len(info.Audio))
+	for _, a := range info.Audio {
+		audio = append(audio, NormalizeLang(a.Language))
+	}
+	if reason := compareLangs("audio", audio, want.Audio); reason != "" {
+		return reason
+	}
+	subs := make([]string, 0, len(info.Subs))
+	for _, s := range info.Subs {
+		subs = append(subs, NormalizeLang(s.Language))
+	}
+	if reason := compareLangs("subtitle", subs, want.Subs); reason != "" {
+		return reason
+	}
+
+	if want.Duration > 0 {
+		actual := info.Duration
+		if actual <= 0 {
+			actual = info.Video.Duration
+		}
+		// при падении ffmpeg matroska остается без длительности или обрывается раньше
+		if diff := want.Duration - actual; diff > durationTolerance || diff < -durationTolerance {
+			return fmt.Sprintf("duration %s instead of %s", actual, want.Duration)
+		}
+	}
+	return ""
+}
+
+func compareLangs(kind string, actual, expected []string) string {
+	if len(actual) != len(expected) {
+		return fmt.Sprintf("%d %s streams instead of %d", len(actual), kind, len(expected))
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			return fmt.Sprintf("%s stream %d language %s instead of %s", kind, i, actual[i], expected[i])
+		}
+	}
+	return ""
+}
+
+// Проверяем готовый файл задания так же, как после кодирования: дорожки и длительность,
+// а если задан job.FullDecode - еще и полным декодированием
+func VerifyOutput(ctx context.Context, ffmpegPath string, file string, job Job) (OutputState, string, error) {
+	state, reason, err := CheckOutput(ctx, file, job.Expected())
+	if err != nil || state != OutputValid || !job.FullDecode {
+		return state, reason, err
+	}
+	reason, err = decodeCheck(ctx, ffmpegPath, file)
+	if err != nil {
+		return OutputMissing, "", err
+	}
+	if reason != "" {
+		return OutputIncomplete, reason, nil
+	}
+	return OutputValid, "", nil
+}
+
+// Полностью декодируем файл: ffmpeg -v error пишет в вывод только ошибки,
+// так что любой вывод означает испорченные кадры.
+// Пустая строка - файл декодируется без ошибок, иначе - что с ним не так
+func decodeCheck(ctx context.Context, ffmpegPath string, file string) (string, error) {
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-nostdin", "-v", "error", "-i", file, "-f", "null", "-")
+	ownProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+	stderr := newTailBuffer(64 * 1024)
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	out := strings.TrimSpace(string(stderr.Bytes()))
+	if err == nil && out == "" {
+		return "", nil
+	}
+	reason := "decode errors"
+	if err != nil {
+		reason = fmt.Sprintf("decode failed: %v", err)
+	}
+	if out != "" {
+		reason += "\n" + tailLines([]byte(out), stderrTailLines)
+	}
+	return reason, nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpectedOutput(t *testing.T) {
+	sel := selection([]int{0, 1}, []int{0})
+	sel.Video.Duration = time.Hour
+	sel.Audio[0].Language = "ru"
+	sel.Audio[1].Language = "eng"
+
+	want := ExpectedOutput(sel, 720)
+	if want.Duration != time.Hour || want.Height != 720 {
+		t.Errorf("Неожиданная длительность или высота: %+v", want)
+	}
+	if strings.Join(want.Audio, ",") != "rus,eng" || strings.Join(want.Subs, ",") != "und" {
+		t.Errorf("Неожиданные языки: %+v", want)
+	}
+}
+
+func TestExpectationMismatch(t *testing.T) {
+	duration := 48 * time.Minute
+	want := Expectation{Duration: duration, Height: 720, Audio: []string{"rus", "eng"}, Subs: []string{"rus"}}
+
+	output := func(change func(info *AllStreamInfo)) AllStreamInfo {
+		info := *NewAllStreamInfo()
+		info.Video.TypeIndex = 0
+		info.Video.Height = 720
+		info.Audio = Audios{{Language: "rus"}, {Language: "eng"}}
+		info.Subs = Subs{{Language: "ru"}}
+		info.Duration = duration + time.Second
+		if change != nil {
+			change(&info)
+		}
+		return info
+	}
+
+	tests := []struct {
+		name     string
+		info     AllStreamInfo
+		expected string
+	}{
+		{"полный файл", output(nil), ""},
+		{"оборван на середине", output(func(i *AllStreamInfo) { i.Duration = duration / 2 }), "duration 24m0s instead of 48m0s"},
+		{"длительность из видеопотока", output(func(i *AllStreamInfo) { i.Duration = 0; i.Video.Duration = duration }), ""},
+		{"нет длительности", output(func(i *AllStreamInfo) { i.Duration = 0 }), "duration 0s instead of 48m0s"},
+		{"не хватает аудио", output(func(i *AllStreamInfo) { i.Audio = i.Audio[:1] }), "1 audio streams instead of 2"},
+		{"аудио в другом порядке", output(func(i *AllStreamInfo) { i.Audio[0], i.Audio[1] = i.Audio[1], i.Audio[0] }), "audio stream 0 language eng instead of rus"},
+		{"лишние субтитры", output(func(i *AllStreamInfo) { i.Subs = append(i.Subs, SubsInfo{}) }), "2 subtitle streams instead of 1"},
+		{"не та высота", output(func(i *AllStreamInfo) { i.Video.Height = 1080 }), "video height 1080p instead of 720p"},
+		{"нет видео", output(func(i *AllStreamInfo) { i.Video.TypeIndex = -1 }), "no video stream"},
+	}
+	for _, test := range tests {
+		if actual := want.mismatch(test.info); actual != test.expected {
+			t.Errorf("%s: ожидалось %q, получено %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	fakeFfmpeg := filepath.Join(dir, "ffmpeg")
+
+	tests := []struct {
+		name   string
+		script string
+		reason string
+	}{
+		{"без ошибок", "exit 0", ""},
+		{"битые кадры", "echo '[hevc @ 0x1] Could not find ref with POC 7' >&2", "decode errors\n[hevc @ 0x1] Could not find ref with POC 7"},
+		{"ошибка ffmpeg", "exit 1", "decode failed: exit status 1"},
+	}
+	for _, test := range tests {
+		if err := os.WriteFile(fakeFfmpeg, []byte("#!/bin/sh\n"+test.script+"\n"), 0755); err != nil {
+			t.Fatalf("Ошибка при создании фейкового ffmpeg: %v", err)
+		}
+		reason, err := decodeCheck(context.Background(), fakeFfmpeg, "output.mkv")
+		if err != nil || reason != test.reason {
+			t.Errorf("%s: ожидалось %q, получено %q (%v)", test.name, test.reason, reason, err)
+		}
+	}
+}
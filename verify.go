@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	u "video-converter/utils"
+)
+
+func runVerify(args []string) int {
+	fs := newFlagSet("verify")
+	var jf jobFlags
+	jf.register(fs)
+	decode := fs.Bool("decode", false, "полностью декодировать готовые файлы, чтобы найти битые кадры")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	conv, files, err := jf.setup(fs.Args())
+	if err != nil {
+		log.Print(err)
+		return exitError
+	}
+	if *decode {
+		if conv.ffmpegPath, err = u.Ffmpeg(); err != nil {
+			log.Print(err)
+			return exitError
+		}
+	}
+	conv.fullDecode = *decode
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	code := exitOK
+	counts := make(map[u.OutputState]int)
+	for _, file := range files {
+		plan, err := conv.prepare(ctx, file)
+		var state u.OutputState
+		var reason string
+		if err == nil {
+			state, reason, err = u.VerifyOutput(ctx, conv.ffmpegPath, plan.job.Output, plan.job)
+		}
+		if ctx.Err() != nil {
+			return exitInterrupted
+		}
+		if err != nil {
+			log.Printf("ERROR: %s\n", err)
+			if errors.Is(err, u.ErrToolNotFound) {
+				return exitError
+			}
+			code = exitFailed
+			continue
+		}
+
+		counts[state]++
+		switch state {
+		case u.OutputValid:
+			fmt.Printf("%-10s %s\n", state, plan.job.Output)
+		case u.OutputIncomplete:
+			fmt.Printf("%-10s %s: %s\n", state, plan.job.Output, reason)
+			code = exitFailed
+		default:
+			fmt.Printf("%-10s %s (source %s)\n", state, plan.job.Output, file)
+			code = exitFailed
+		}
+	}
+	fmt.Printf("Verified: %d valid, %d incomplete, %d missing\n", counts[u.OutputValid], counts[u.OutputIncomplete], counts[u.OutputMissing])
+	return code
+}